@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// Capture file format, written by 'spy --record' and read by 'replay'.
+// The file is made of Json lines: a header followed by one line per message.
+const (
+	captureFormat  = "vbus-cmd-capture"
+	captureVersion = 1
+)
+
+// First line of a capture file.
+type captureHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Start   string `json:"start"`
+}
+
+// A captured message.
+type captureRecord struct {
+	Offset  int64  `json:"offset"` // nanoseconds elapsed since the capture start
+	Time    string `json:"time"`
+	Subject string `json:"subject"`
+	Reply   string `json:"reply,omitempty"`
+	Data    []byte `json:"data"` // base64 encoded
+}
+
+// Write captured messages to a file.
+type captureWriter struct {
+	file    *os.File
+	encoder *json.Encoder
+	start   time.Time
+}
+
+// Create a capture file and write its header.
+func newCaptureWriter(filename string) (*captureWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create capture file")
+	}
+
+	w := &captureWriter{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		start:   time.Now(),
+	}
+
+	err = w.encoder.Encode(captureHeader{
+		Format:  captureFormat,
+		Version: captureVersion,
+		Start:   w.start.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.Wrap(err, "cannot write capture file")
+	}
+	return w, nil
+}
+
+// Append a message to the capture file.
+func (w *captureWriter) Write(m *nats.Msg) error {
+	now := time.Now()
+	return w.encoder.Encode(captureRecord{
+		Offset:  now.Sub(w.start).Nanoseconds(),
+		Time:    now.Format(time.RFC3339Nano),
+		Subject: m.Subject,
+		Reply:   m.Reply,
+		Data:    m.Data,
+	})
+}
+
+func (w *captureWriter) Close() error {
+	return w.file.Close()
+}
+
+// Read a capture file.
+func readCaptureFile(filename string) ([]captureRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot open capture file")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // payloads can be big
+
+	var header captureHeader
+	if !scanner.Scan() {
+		return nil, errors.New("empty capture file")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != captureFormat {
+		return nil, errors.New("not a vbus-cmd capture file")
+	}
+	if header.Version != captureVersion {
+		return nil, fmt.Errorf("unsupported capture file version: %d", header.Version)
+	}
+
+	var records []captureRecord
+	for line := 2; scanner.Scan(); line++ {
+		var record captureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Wrapf(err, "invalid capture record on line %d", line)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "cannot read capture file")
+	}
+	return records, nil
+}
+
+// Apply subject rewriting rules, each rule is a "from=to" string replacement.
+func rewriteSubject(subject string, rules []string) string {
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		subject = strings.Replace(subject, parts[0], parts[1], -1)
+	}
+	return subject
+}
+
+// Publish captured messages, keeping their relative timing.
+// The speed factor scales the timing (2 is twice faster), 0 publishes without any delay.
+func replayCapture(client *nats.Conn, records []captureRecord, speed float64, rules []string) error {
+	for _, rule := range rules {
+		if !strings.Contains(rule, "=") {
+			return validationError("invalid rewrite rule (expected FROM=TO): " + rule)
+		}
+	}
+
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	defer signal.Stop(signalChannel)
+
+	start := time.Now()
+	for i, record := range records {
+		if speed > 0 {
+			at := start.Add(time.Duration(float64(record.Offset) / speed))
+			select {
+			case <-signalChannel:
+				log.Printf("replay interrupted after %d messages", i)
+				return nil
+			case <-time.After(time.Until(at)):
+			}
+		}
+
+		subject := rewriteSubject(record.Subject, rules)
+		if badSubject(subject) {
+			return errors.New("invalid subject after rewriting: " + subject)
+		}
+
+		err := client.PublishMsg(&nats.Msg{Subject: subject, Reply: record.Reply, Data: record.Data})
+		if err != nil {
+			return errors.Wrap(err, "cannot publish "+subject)
+		}
+	}
+
+	log.Printf("%d messages replayed", len(records))
+	return client.Flush()
+}
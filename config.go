@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The vBus client config file (<domain>.<app>.conf), written by vbus.go on first connection.
+type clientConfig struct {
+	Client struct {
+		User        string `json:"user"`
+		Password    string `json:"password"` // hashed password, sent to the hub
+		Permissions struct {
+			Subscribe []string `json:"subscribe"`
+			Publish   []string `json:"publish"`
+		} `json:"permissions"`
+	} `json:"client"`
+	Key struct {
+		Private string `json:"private"` // clear password
+	} `json:"key"`
+	Vbus struct {
+		Url       string `json:"url"`
+		NetworkIp string `json:"networkIp"`
+		Hostname  string `json:"hostname"`
+	} `json:"vbus"`
+}
+
+// Get the folder of vBus config files, from VBUS_PATH or in $HOME.
+func getVbusPath() string {
+	if vbusPath := os.Getenv("VBUS_PATH"); vbusPath != "" {
+		return vbusPath
+	}
+	return path.Join(os.Getenv("HOME"), "vbus")
+}
+
+// Get the config file path of a vBus client.
+func getClientConfigPath(domain, app string) string {
+	return path.Join(getVbusPath(), domain+"."+app+".conf")
+}
+
+// Load the config file of a vBus client.
+func loadClientConfig(domain, app string) (*clientConfig, error) {
+	filename := getClientConfigPath(domain, app)
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, errors.New("no vBus config file for " + domain + "." + app + " (it is created on first connection): " + filename)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read vBus config file")
+	}
+
+	var config clientConfig
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return nil, errors.Wrap(err, "invalid vBus config file "+filename)
+	}
+
+	var missing []string
+	for _, field := range []struct{ name, value string }{
+		{"client.user", config.Client.User},
+		{"key.private", config.Key.Private},
+	} {
+		if field.value == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("invalid vBus config file %s: missing %s", filename, strings.Join(missing, ", "))
+	}
+	return &config, nil
+}
+
+// Get the Nats server url. vbus.go saves the url it connected to in the config file, so it is used first.
+// Without it, vbus.go strategies are followed: the hub serial (--profile) when it resolves, then VBUS_URL.
+func (c *clientConfig) getServerUrl() (string, error) {
+	if c.Vbus.Url != "" {
+		return c.Vbus.Url, nil
+	}
+	if hubSerial != "" {
+		url, err := getHubUrl(hubSerial)
+		if err == nil {
+			return url, nil
+		}
+		logR.WithFields(lf{"serial": hubSerial, "error": err.Error()}).Debug("cannot resolve hub, trying VBUS_URL")
+	}
+	if url := os.Getenv("VBUS_URL"); url != "" {
+		return url, nil
+	}
+	return "", errors.New("cannot find a vBus url: none saved in the config file, no hub serial or VBUS_URL")
+}
+
+// Get the Nats server url of a hub from its serial (or ip address).
+func getHubUrl(serial string) (string, error) {
+	host := serial
+	if net.ParseIP(host) == nil {
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			return "", errors.Wrap(err, "cannot resolve hub "+serial)
+		}
+		if len(addrs) == 0 {
+			return "", errors.New("cannot resolve hub " + serial)
+		}
+		host = addrs[0].String()
+	}
+	return "nats://" + host + ":21400", nil
+}
+
+// Find the most recently used vbus-cmd app name (an identity or temporary UUID) of a domain in the vBus path,
+// empty when there is none. Config files of other apps are ignored, they are used with --app.
+func findClientConfigApp(domain string) (string, error) {
+	files, err := ioutil.ReadDir(getVbusPath())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "cannot list vBus config files")
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, domain+".") || !strings.HasSuffix(name, ".conf") {
+			continue
+		}
+		if app := strings.TrimSuffix(strings.TrimPrefix(name, domain+"."), ".conf"); isUuid(app) {
+			return app, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestGetServerUrl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vbus-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(serial, vbusPath, vbusUrl string) {
+		hubSerial = serial
+		_ = os.Setenv("VBUS_PATH", vbusPath)
+		_ = os.Setenv("VBUS_URL", vbusUrl)
+	}(hubSerial, os.Getenv("VBUS_PATH"), os.Getenv("VBUS_URL"))
+	_ = os.Setenv("VBUS_PATH", dir)
+
+	// a profile with a url and a serial that does not resolve here
+	profileFile := path.Join(dir, "vbus-cmd.yaml")
+	profileYaml := "profiles:\n  hub1:\n    url: nats://192.0.2.10:21400\n    serial: NOTAHUB.invalid\n"
+	if err := ioutil.WriteFile(profileFile, []byte(profileYaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := loadProfile(profileFile, "hub1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.apply(cli.NewContext(cli.NewApp(), flag.NewFlagSet("test", flag.ContinueOnError), nil))
+
+	tests := []struct {
+		name    string
+		saved   string // url saved by vbus.go in the config file
+		want    string
+		wantErr bool
+	}{
+		{"saved url first", "nats://192.0.2.20:21400", "nats://192.0.2.20:21400", false},
+		{"unresolved serial falls back to VBUS_URL", "", "nats://192.0.2.10:21400", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := `{"client": {"user": "system.test.host"}, "key": {"private": "secret"}, "vbus": {"url": "` + tt.saved + `"}}`
+			if err := ioutil.WriteFile(getClientConfigPath("system", "test"), []byte(conf), 0600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := loadClientConfig("system", "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			url, err := config.getServerUrl()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url != tt.want {
+				t.Errorf("got %s, want %s", url, tt.want)
+			}
+		})
+	}
+
+	_ = os.Unsetenv("VBUS_URL")
+	config := &clientConfig{}
+	if _, err := config.getServerUrl(); err == nil {
+		t.Error("expected an error without any url")
+	}
+}
@@ -2,36 +2,38 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"strings"
-	"time"
 
-	"github.com/jeremywohl/flatten"
+	"github.com/pkg/errors"
 	"github.com/tidwall/pretty"
 	"github.com/veeainc/utils.go/system"
 	"github.com/veeainc/utils.go/types"
 	vBus "github.com/veeainc/vbus.go"
 )
 
-// Get a new vBus connection.
-func getConnection(domain, appName, password string, permissions []string, wait bool) *vBus.Client {
+// Get a new vBus connection, retried with the --wait policy.
+func getConnection(domain, appName, password string, permissions []string, wait bool) (*vBus.Client, error) {
 	conn := vBus.NewClient(domain, appName)
-	connected := false
-	for !connected {
-		if err := conn.Connect(vBus.WithPwd(password), vBus.WithPermissionSlice(permissions)); err != nil {
-			if wait {
-				log.Print(err.Error())
-				time.Sleep(30 * time.Second)
-			} else {
-				log.Fatal(err.Error())
-			}
-		} else {
-			connected = true
+	permissions = append(append([]string{}, permissions...), profilePermissions...)
+	connect := func() error {
+		if hubSerial != "" {
+			return conn.Connect(vBus.WithPwd(password), vBus.WithPermissionSlice(permissions), vBus.HubId(hubSerial))
 		}
+		return conn.Connect(vBus.WithPwd(password), vBus.WithPermissionSlice(permissions))
+	}
+
+	var err error
+	if wait {
+		err = retry.run("vBus connection", connect)
+	} else {
+		err = connect()
 	}
-	return conn
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
 }
 
 // Replace `local` keyword by vBus hostname.
@@ -40,50 +42,59 @@ func sanitizePath(path string, conn *vBus.Client) string {
 }
 
 // Get a remote attribute.
-func getAttribute(path string, conn *vBus.Client) *vBus.AttributeProxy {
+func getAttribute(path string, conn *vBus.Client) (*vBus.AttributeProxy, error) {
 	path = sanitizePath(path, conn)
-	if attr, err := conn.GetRemoteAttr(path); err != nil {
-		log.Print(err.Error())
-	} else {
-		return attr
+	attr, err := conn.GetRemoteAttr(path)
+	return attr, errors.Wrap(err, "attribute not available: "+path)
+}
+
+// Set an attribute value without the schema validation done by vbus.go, by publishing
+// directly on its value set subject with a raw Nats connection.
+func setAttributeValueRaw(attr *vBus.AttributeProxy, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return withClass(classValidation, errors.Wrap(err, "cannot encode value"))
 	}
-	return nil
+	client, err := getNatsConnection()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	subject := attr.GetPath() + ".value.set"
+	if err := client.Publish(subject, data); err != nil {
+		return errors.Wrap(err, "cannot publish "+subject)
+	}
+	return client.Flush()
 }
 
 // Get a remote node.
-func getNode(path string, conn *vBus.Client) *vBus.UnknownProxy {
+func getNode(path string, conn *vBus.Client) (*vBus.UnknownProxy, error) {
 	path = sanitizePath(path, conn)
-	if attr, err := conn.GetRemoteElement(path); err != nil {
-		log.Print(err.Error())
-	} else {
-		return attr
-	}
-	return nil
+	node, err := conn.GetRemoteElement(path)
+	return node, errors.Wrap(err, "node not available: "+path)
 }
 
 // Ask vBus permission.
-func askPermission(path string, conn *vBus.Client) {
+func askPermission(path string, conn *vBus.Client) error {
 	if badSubject(path) {
-		log.Print(errors.New("invalid vBus path: " + path))
+		return validationError("invalid vBus path: " + path)
 	}
-	if success, err := conn.AskPermission(path); err != nil {
-		log.Print(err.Error())
-	} else {
-		if !success {
-			log.Print("cannot get permission: ", path)
-		}
+	success, err := conn.AskPermission(path)
+	if err != nil {
+		return errors.Wrap(err, "cannot ask permission "+path)
+	}
+	if !success {
+		return withClass(classPermission, errors.New("cannot get permission: "+path))
 	}
+	return nil
 }
 
 // Get a remote method.
-func getMethod(path string, conn *vBus.Client) *vBus.MethodProxy {
+func getMethod(path string, conn *vBus.Client) (*vBus.MethodProxy, error) {
 	path = sanitizePath(path, conn)
-	if meth, err := conn.GetRemoteMethod(path); err != nil {
-		log.Print(err.Error())
-	} else {
-		return meth
-	}
-	return nil
+	meth, err := conn.GetRemoteMethod(path)
+	return meth, errors.Wrap(err, "method not available: "+path)
 }
 
 // Parse Json string to Go and abort in case of error.
@@ -122,47 +133,43 @@ func goToPrettyColoredJson(val interface{}) string {
 	return ""
 }
 
-func traverseNode(node *vBus.NodeProxy, level int) {
-	for name, elem := range node.Elements() {
-		if elem.IsNode() {
-			n := elem.AsNode()
-			fmt.Printf("%s%s:\n", strings.Repeat(" ", level*2), name)
-			traverseNode(n, level+1)
-		} else if elem.IsAttribute() {
-			attr := elem.AsAttribute()
-			fmt.Printf("%s%s = %v\n", strings.Repeat(" ", level*2), name, attr.Value())
-		} else if elem.IsMethod() {
-			fmt.Printf("%s%s\n", strings.Repeat(" ", level*2), name)
-			fmt.Printf("  %sParams: %s\n", strings.Repeat(" ", level*2), goToJson(elem.Tree().(map[string]interface{})["params"].(map[string]interface{})["schema"]))
-		}
+// Get the element kind (node, attribute or method) of a raw vBus tree.
+func getElementKind(tree vBus.JsonAny) string {
+	if vBus.IsAttribute(tree) {
+		return "attribute"
+	} else if vBus.IsMethod(tree) {
+		return "method"
 	}
+	return "node"
 }
 
-func dumpElement(elem *vBus.UnknownProxy) {
-	if elem.IsNode() {
-		traverseNode(elem.AsNode(), 0)
+// Cut a raw vBus tree after `depth` node levels (0 means no limit).
+// Cut nodes are replaced by a short string giving their children count.
+func limitTreeDepth(tree vBus.JsonAny, depth int) vBus.JsonAny {
+	obj, ok := tree.(vBus.JsonObj)
+	if depth <= 0 || !ok || !vBus.IsNode(obj) {
+		return tree
 	}
-}
 
-func dumpElementToColoredJson(elem *vBus.UnknownProxy) {
-	fmt.Println(goToPrettyColoredJson(elem.Tree()))
-}
-
-func dumpElementFlattened(elem *vBus.UnknownProxy) {
-	if casted, ok := elem.Tree().(map[string]interface{}); ok {
-		flat, err := flatten.Flatten(casted, "", flatten.DotStyle)
-		if err != nil {
-			log.Print(err)
-			return
-		}
-		for k, v := range flat {
-			fmt.Printf("%s %v\n", k, v)
+	limited := vBus.JsonObj{}
+	for k, v := range obj {
+		if child, ok := v.(vBus.JsonObj); ok && vBus.IsNode(child) {
+			if depth == 1 && len(child) > 0 {
+				limited[k] = fmt.Sprintf("(%d children)", len(child))
+			} else {
+				limited[k] = limitTreeDepth(child, depth-1)
+			}
+		} else {
+			limited[k] = v
 		}
 	}
+	return limited
 }
 
 // Try to convert a Json obj to a vBus raw node.
-func jsonObjToRawDef(tree vBus.JsonAny) vBus.RawNode {
+// When a state is provided, attribute values are read from and persisted to it.
+// The path is the node path relative to the created node (nil for the root).
+func jsonObjToRawDef(tree vBus.JsonAny, path []string, state *nodeState) vBus.RawNode {
 	if _, ok := tree.(vBus.JsonObj); !ok {
 		log.Print("Not a valid Json object")
 		return nil
@@ -176,14 +183,39 @@ func jsonObjToRawDef(tree vBus.JsonAny) vBus.RawNode {
 
 	rawNode := vBus.RawNode{}
 	for k, v := range obj {
-		if types.IsMap(v) {
-			rawNode[k] = vBus.NewNodeDef(jsonObjToRawDef(v))
+		if v == nil {
+			// no schema can be inferred from a null value (e.g. 'note:' in yaml)
+			log.Print("Attribute without value: " + k)
+			return nil
+		} else if method, ok := types.GetKey(v, "$method").(vBus.JsonObj); ok {
+			def, err := jsonObjToMethodDef(method)
+			if err != nil {
+				log.Print("Not a valid method: " + k + ": " + err.Error())
+				return nil
+			}
+			rawNode[k] = def
+		} else if types.IsMap(v) {
+			child := jsonObjToRawDef(v, appendPath(path, k), state)
+			if child == nil {
+				return nil
+			}
+			rawNode[k] = vBus.NewNodeDef(child)
 		} else if vBus.IsNode(v) {
-			rawNode[k] = vBus.NewAttributeDef(k, v)
+			if state != nil {
+				rawNode[k] = state.newAttributeDef(appendPath(path, k), v)
+			} else {
+				rawNode[k] = vBus.NewAttributeDef(k, v)
+			}
 		} else {
-			log.Fatal("Only attribute and node are supported")
+			log.Print("Only attribute, method and node are supported")
+			return nil
 		}
 	}
 
 	return rawNode
 }
+
+// Append a segment to a path without modifying the original slice.
+func appendPath(path []string, segment string) []string {
+	return append(append([]string{}, path...), segment)
+}
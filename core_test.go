@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// A minimal Nats server accepting one client, published messages are sent on the returned channel.
+func startFakeNatsServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := make(chan string, 10)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				_, _ = fmt.Fprint(conn, "PONG\r\n")
+			case fields[0] == "PUB" && len(fields) == 3:
+				payload, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				published <- fields[1] + " " + strings.TrimSpace(payload)
+			}
+		}
+	}()
+	return "nats://" + listener.Addr().String(), published
+}
+
+func TestSetAttributeValueRawWithProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vbus-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(serial, d, app, vbusPath, vbusUrl string) {
+		hubSerial, domain, appName = serial, d, app
+		_ = os.Setenv("VBUS_PATH", vbusPath)
+		_ = os.Setenv("VBUS_URL", vbusUrl)
+	}(hubSerial, domain, appName, os.Getenv("VBUS_PATH"), os.Getenv("VBUS_URL"))
+	_ = os.Setenv("VBUS_PATH", dir)
+	domain, appName = "system", "test"
+
+	// a profile with the hub url and a serial that does not resolve here
+	url, published := startFakeNatsServer(t)
+	profileFile := path.Join(dir, "vbus-cmd.yaml")
+	profileYaml := "profiles:\n  hub1:\n    url: " + url + "\n    serial: NOTAHUB.invalid\n"
+	if err := ioutil.WriteFile(profileFile, []byte(profileYaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profile, err := loadProfile(profileFile, "hub1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.apply(cli.NewContext(cli.NewApp(), flag.NewFlagSet("test", flag.ContinueOnError), nil))
+
+	conf := `{"client": {"user": "system.test.host"}, "key": {"private": "secret"}, "vbus": {"url": ""}}`
+	if err := ioutil.WriteFile(getClientConfigPath(domain, appName), []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	attr := vBus.NewAttributeProxy(nil, "system.foo.host.config.level", vBus.JsonObj{"schema": vBus.JsonObj{"type": "integer"}})
+	if err := setAttributeValueRaw(attr, "loud"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-published:
+		if want := `system.foo.host.config.level.value.set "loud"`; msg != want {
+			t.Errorf("published %s, want %s", msg, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing published")
+	}
+}
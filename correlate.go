@@ -0,0 +1,116 @@
+package main
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A request waiting for its reply.
+type pendingRequest struct {
+	subject string
+	reply   string
+	data    []byte
+	sent    time.Time
+	expired bool // true when no reply has been received within the reply window
+}
+
+// A correlated request, printed as a Json line.
+type correlatedRequest struct {
+	Time      string      `json:"time"`
+	Status    string      `json:"status"` // "replied", "late" or "timeout"
+	Subject   string      `json:"subject"`
+	Reply     string      `json:"reply"`
+	Request   interface{} `json:"request"`
+	Response  interface{} `json:"response,omitempty"`
+	LatencyMs float64     `json:"latencyMs,omitempty"`
+}
+
+// Pair requests with their replies using the reply subject (inbox).
+// It is not safe for concurrent use.
+type correlator struct {
+	window  time.Duration // delay after which a request without reply is flagged
+	json    bool          // print Json lines instead of log lines
+	pending map[string]*pendingRequest
+}
+
+func newCorrelator(window time.Duration, json bool) *correlator {
+	return &correlator{
+		window:  window,
+		json:    json,
+		pending: make(map[string]*pendingRequest),
+	}
+}
+
+// Handle a message, it returns true if something has been printed.
+// The display filter only applies to requests, replies are always matched.
+func (c *correlator) handle(m *nats.Msg, displayed bool) bool {
+	if req, ok := c.pending[m.Subject]; ok {
+		delete(c.pending, m.Subject)
+		status := "replied"
+		if req.expired {
+			status = "late"
+		}
+		c.print(req, status, m.Data, time.Since(req.sent))
+		return true
+	}
+
+	if m.Reply != "" && displayed {
+		c.pending[m.Reply] = &pendingRequest{
+			subject: m.Subject,
+			reply:   m.Reply,
+			data:    m.Data,
+			sent:    time.Now(),
+		}
+	}
+	return false
+}
+
+// Flag requests without reply, it returns the number of printed requests.
+// Flagged requests are kept a while to detect late replies.
+func (c *correlator) expire() int {
+	count := 0
+	for reply, req := range c.pending {
+		elapsed := time.Since(req.sent)
+		if !req.expired && elapsed > c.window {
+			req.expired = true
+			c.print(req, "timeout", nil, elapsed)
+			count++
+		} else if req.expired && elapsed > 10*c.window {
+			delete(c.pending, reply) // forget it
+		}
+	}
+	return count
+}
+
+func (c *correlator) print(req *pendingRequest, status string, response []byte, latency time.Duration) {
+	if c.json {
+		event := correlatedRequest{
+			Time:      time.Now().Format(time.RFC3339Nano),
+			Status:    status,
+			Subject:   req.subject,
+			Reply:     req.reply,
+			Request:   decodeMsgData(req.data),
+			Response:  decodeMsgData(response),
+			LatencyMs: float64(latency) / float64(time.Millisecond),
+		}
+		if status == "timeout" {
+			event.LatencyMs = 0
+		}
+		printOutput(event, formatJson)
+		return
+	}
+
+	entry := logR.WithFields(lf{
+		"subject": req.subject,
+		"request": string(req.data),
+	})
+	switch status {
+	case "replied":
+		entry.WithFields(lf{"response": string(response), "latency": latency}).Info("vBus Request")
+	case "late":
+		entry.WithFields(lf{"response": string(response), "latency": latency}).Warn("vBus Request (late reply)")
+	default:
+		entry.WithFields(lf{"waited": latency}).Warn("vBus Request (no reply)")
+	}
+}
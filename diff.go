@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+
+	"github.com/veeainc/utils.go/system"
+)
+
+// Kinds of differences between two snapshots.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffKind    = "kind"   // element kind changed (e.g. attribute to node)
+	diffValue   = "value"  // attribute value changed
+	diffSchema  = "schema" // attribute schema or method signature changed
+)
+
+// A difference between two snapshots.
+type diffEntry struct {
+	Change string      `json:"change"`
+	Path   string      `json:"path"`
+	Kind   string      `json:"kind"`
+	Field  string      `json:"field,omitempty"` // changed leaf, e.g. "schema.maximum" or "value.ip"
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// Compare two snapshots. Values and schemas are compared leaf by leaf on their flattened view.
+func diffSnapshots(a, b *snapshot) []diffEntry {
+	var entries []diffEntry
+
+	for _, path := range getSnapshotPaths(a, b) {
+		oldElem, inA := a.Elements[path]
+		newElem, inB := b.Elements[path]
+
+		switch {
+		case !inB:
+			entries = append(entries, diffEntry{Change: diffRemoved, Path: path, Kind: oldElem.Kind, Old: oldElem.Value})
+		case !inA:
+			entries = append(entries, diffEntry{Change: diffAdded, Path: path, Kind: newElem.Kind, New: newElem.Value})
+		case oldElem.Kind != newElem.Kind:
+			entries = append(entries, diffEntry{Change: diffKind, Path: path, Kind: newElem.Kind, Old: oldElem.Kind, New: newElem.Kind})
+		default:
+			entries = append(entries, diffLeaves(diffSchema, path, newElem.Kind, "schema", oldElem.Schema, newElem.Schema)...)
+			entries = append(entries, diffLeaves(diffSchema, path, newElem.Kind, "params", oldElem.Params, newElem.Params)...)
+			entries = append(entries, diffLeaves(diffSchema, path, newElem.Kind, "returns", oldElem.Returns, newElem.Returns)...)
+			entries = append(entries, diffLeaves(diffValue, path, newElem.Kind, "value", oldElem.Value, newElem.Value)...)
+		}
+	}
+	return entries
+}
+
+// Get the sorted union of element paths of two snapshots.
+func getSnapshotPaths(a, b *snapshot) []string {
+	union := make(map[string]interface{})
+	for path := range a.Elements {
+		union[path] = nil
+	}
+	for path := range b.Elements {
+		union[path] = nil
+	}
+	return sortedKeys(union)
+}
+
+// Compare two values on their flattened view, one entry is returned per changed leaf.
+func diffLeaves(change, path, kind, field string, oldValue, newValue interface{}) []diffEntry {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return nil
+	}
+
+	oldFlat, errOld := flattenValue(oldValue)
+	newFlat, errNew := flattenValue(newValue)
+	if errOld != nil || errNew != nil {
+		return []diffEntry{{Change: change, Path: path, Kind: kind, Field: field, Old: oldValue, New: newValue}}
+	}
+
+	var entries []diffEntry
+	union := make(map[string]interface{})
+	for k := range oldFlat {
+		union[k] = nil
+	}
+	for k := range newFlat {
+		union[k] = nil
+	}
+	for _, k := range sortedKeys(union) {
+		if reflect.DeepEqual(oldFlat[k], newFlat[k]) {
+			continue
+		}
+		leaf := field
+		if k != "" {
+			leaf += "." + k
+		}
+		entries = append(entries, diffEntry{Change: change, Path: path, Kind: kind, Field: leaf, Old: oldFlat[k], New: newFlat[k]})
+	}
+	return entries
+}
+
+// Print differences as text, schema changes are listed in their own section.
+func printDiff(entries []diffEntry) {
+	color := func(code string, text string) string {
+		if system.IsTty() {
+			return "\033[" + code + "m" + text + "\033[0m"
+		}
+		return text
+	}
+
+	var schemaEntries []diffEntry
+	for _, e := range entries {
+		switch e.Change {
+		case diffAdded:
+			fmt.Println(color("32", fmt.Sprintf("+ %s (%s)", e.Path, e.Kind)))
+		case diffRemoved:
+			fmt.Println(color("31", fmt.Sprintf("- %s (%s)", e.Path, e.Kind)))
+		case diffKind:
+			fmt.Println(color("33", fmt.Sprintf("~ %s: %v -> %v", e.Path, e.Old, e.New)))
+		case diffValue:
+			fmt.Printf("~ %s%s: %s -> %s\n", e.Path, getDiffFieldSuffix(e.Field, "value"),
+				color("31", formatDiffValue(e.Old)), color("32", formatDiffValue(e.New)))
+		case diffSchema:
+			schemaEntries = append(schemaEntries, e)
+		}
+	}
+
+	if len(schemaEntries) > 0 {
+		fmt.Println()
+		fmt.Println(color("1;35", "Schema changes:"))
+		for _, e := range schemaEntries {
+			fmt.Println(color("35", fmt.Sprintf("! %s %s: %s -> %s", e.Path, e.Field, formatDiffValue(e.Old), formatDiffValue(e.New))))
+		}
+	}
+}
+
+// Get the part of a changed field after its root (e.g. ".ip" for "value.ip").
+func getDiffFieldSuffix(field string, root string) string {
+	if len(field) > len(root) {
+		return field[len(root):]
+	}
+	return ""
+}
+
+// Format a changed value, missing values are shown as "(none)".
+func formatDiffValue(value interface{}) string {
+	if value == nil {
+		return "(none)"
+	}
+	return goToJson(value)
+}
+
+// Tell if a diff side is a snapshot file rather than a vBus path.
+func isSnapshotFile(side string) bool {
+	info, err := os.Stat(side)
+	return err == nil && !info.IsDir()
+}
+
+// Count differences by change kind, sorted by name.
+func countDiffChanges(entries []diffEntry) []string {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Change]++
+	}
+	var list []string
+	for change, count := range counts {
+		list = append(list, fmt.Sprintf("%d %s", count, change))
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	vBus "github.com/veeainc/vbus.go"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	a := &snapshot{Elements: map[string]*snapshotElement{
+		"controller":         {Kind: "node"},
+		"controller.channel": {Kind: "attribute", Schema: vBus.JsonObj{"type": "integer", "maximum": 26.0}, Value: 11.0},
+		"controller.network": {Kind: "attribute", Value: map[string]interface{}{"ip": "10.0.0.1", "mask": 24.0}},
+		"controller.scan":    {Kind: "method", Params: vBus.JsonObj{"type": "array"}},
+		"legacy":             {Kind: "attribute", Value: true},
+		"mode":               {Kind: "attribute", Value: "auto"},
+	}}
+	b := &snapshot{Elements: map[string]*snapshotElement{
+		"controller":         {Kind: "node"},
+		"controller.channel": {Kind: "attribute", Schema: vBus.JsonObj{"type": "integer", "maximum": 25.0}, Value: 15.0},
+		"controller.network": {Kind: "attribute", Value: map[string]interface{}{"ip": "10.0.0.2", "mask": 24.0}},
+		"controller.scan":    {Kind: "method", Params: vBus.JsonObj{"type": "array"}},
+		"mode":               {Kind: "node"},
+		"pairing":            {Kind: "attribute", Value: false},
+	}}
+
+	want := []diffEntry{
+		{Change: diffSchema, Path: "controller.channel", Kind: "attribute", Field: "schema.maximum", Old: 26.0, New: 25.0},
+		{Change: diffValue, Path: "controller.channel", Kind: "attribute", Field: "value", Old: 11.0, New: 15.0},
+		{Change: diffValue, Path: "controller.network", Kind: "attribute", Field: "value.ip", Old: "10.0.0.1", New: "10.0.0.2"},
+		{Change: diffRemoved, Path: "legacy", Kind: "attribute", Old: true},
+		{Change: diffKind, Path: "mode", Kind: "node", Old: "attribute", New: "node"},
+		{Change: diffAdded, Path: "pairing", Kind: "attribute", New: false},
+	}
+	if got := diffSnapshots(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	if got := diffSnapshots(a, a); len(got) != 0 {
+		t.Errorf("expected no difference with itself, got %+v", got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// Error classes, reported in Json errors.
+const (
+	classFailure    = "failure"
+	classConnection = "connection"
+	classPermission = "permission_denied"
+	classNotFound   = "not_found"
+	classTimeout    = "timeout"
+	classValidation = "validation"
+	classRemote     = "remote"
+)
+
+// Process exit codes.
+const (
+	exitFailure    = 1 // unclassified failure
+	exitConnection = 2 // vBus connection failed or timed out
+	exitPermission = 3 // permission denied
+	exitNotFound   = 4 // element not found
+	exitTimeout    = 5 // request timed out
+	exitValidation = 6 // invalid arguments, input or value
+	exitRemote     = 7 // error returned by a remote element
+
+	exitDifferences = 8 // 'diff --exit-code' found differences, not an error class
+)
+
+// Exit code of each error class.
+var exitCodes = map[string]int{
+	classFailure:    exitFailure,
+	classConnection: exitConnection,
+	classPermission: exitPermission,
+	classNotFound:   exitNotFound,
+	classTimeout:    exitTimeout,
+	classValidation: exitValidation,
+	classRemote:     exitRemote,
+}
+
+// vBus error codes, see vBus.ErrorCode.
+const (
+	vbusErrorPathNotFound = 1000
+	vbusErrorValidation   = 3000
+)
+
+// Parse the code of a vBus.VbusError message: "vbus error: <message> (<code>) - <detail>".
+var vbusErrorCode = regexp.MustCompile(`\((\d+)\) - `)
+
+// Missing required flags error of urfave/cli, its type is not exported.
+var requiredFlagsError = regexp.MustCompile(`^Required flags? ".*" not set$`)
+
+// An error with a class, it implements cli.ExitCoder.
+type classError struct {
+	class string
+	err   error
+}
+
+func (e *classError) Error() string { return e.err.Error() }
+func (e *classError) Cause() error  { return e.err }
+func (e *classError) ExitCode() int { return exitCodes[e.class] }
+
+// Set the class of an error (nil is returned as is).
+func withClass(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classError{class: class, err: err}
+}
+
+// Set the validation class on command line usage errors (unknown flags, invalid flag values),
+// on the app and on every command.
+func setUsageErrorHandler(app *cli.App) {
+	onUsageError := func(c *cli.Context, err error, isSubcommand bool) error {
+		return withClass(classValidation, errors.Wrap(err, "incorrect usage (see --help)"))
+	}
+
+	var setCommands func(commands []*cli.Command)
+	setCommands = func(commands []*cli.Command) {
+		for _, command := range commands {
+			command.OnUsageError = onUsageError
+			setCommands(command.Subcommands)
+		}
+	}
+	app.OnUsageError = onUsageError
+	setCommands(app.Commands)
+}
+
+// Get a new validation error, for invalid arguments or values.
+func validationError(msg string) error {
+	return withClass(classValidation, errors.New(msg))
+}
+
+// Get a new not found error.
+func notFoundError(msg string) error {
+	return withClass(classNotFound, errors.New(msg))
+}
+
+// Set the connection class on a connection error, unless it is an authorization error.
+func connectionError(err error) error {
+	if getErrorClass(err) == classPermission { // i.e. a wrong password
+		return err
+	}
+	return withClass(classConnection, err)
+}
+
+// Get the class of an error: the first class set on the error chain, or the class of its cause.
+func getErrorClass(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *classError:
+			return e.class
+		case vBus.ValidationError:
+			return classValidation
+		case vBus.VbusError:
+			return getVbusErrorClass(e)
+		}
+
+		switch err {
+		case nats.ErrTimeout:
+			return classTimeout
+		case nats.ErrAuthorization, nats.ErrAuthExpired:
+			return classPermission
+		case nats.ErrNoServers, nats.ErrConnectionClosed, nats.ErrInvalidConnection, nats.ErrStaleConnection:
+			return classConnection
+		}
+		if strings.Contains(err.Error(), nats.PERMISSIONS_ERR) {
+			return classPermission
+		}
+		if requiredFlagsError.MatchString(err.Error()) {
+			return classValidation
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return classFailure
+}
+
+// Get the class of an error returned by a remote element.
+func getVbusErrorClass(err vBus.VbusError) string {
+	match := vbusErrorCode.FindStringSubmatch(err.Error())
+	if match == nil {
+		return classRemote
+	}
+	switch code, _ := strconv.Atoi(match[1]); code {
+	case vbusErrorPathNotFound:
+		return classNotFound
+	case vbusErrorValidation:
+		return classValidation
+	}
+	return classRemote
+}
+
+// Get the process exit code of an error.
+func getExitCode(err error) int {
+	for e := err; e != nil; {
+		if _, ok := e.(*classError); ok {
+			break
+		}
+		if exitErr, ok := e.(cli.ExitCoder); ok {
+			return exitErr.ExitCode() // e.g. 'diff --exit-code'
+		}
+		cause, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = cause.Cause()
+	}
+	return exitCodes[getErrorClass(err)]
+}
+
+// An error printed with '--output json'.
+type jsonError struct {
+	Class    string `json:"class"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message"`
+}
+
+// Print an error as a Json object on stdout: {"error": {"class": ..., "exit_code": ..., "message": ...}}.
+func printJsonError(err error, code int) {
+	_ = json.NewEncoder(os.Stdout).Encode(map[string]jsonError{
+		"error": {Class: getErrorClass(err), ExitCode: code, Message: err.Error()},
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestRunUsageErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vbus-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(vbusPath string) { _ = os.Setenv("VBUS_PATH", vbusPath) }(os.Getenv("VBUS_PATH"))
+	_ = os.Setenv("VBUS_PATH", dir)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown app flag", []string{"vbus-cmd", "--bogus", "version"}},
+		{"invalid app flag value", []string{"vbus-cmd", "--max-retries", "many", "version"}},
+		{"unknown command flag", []string{"vbus-cmd", "identity", "list", "--bogus"}},
+		{"unknown flag with --loop", []string{"vbus-cmd", "--loop", "identity", "list", "--bogus"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := run(tt.args); code != exitValidation {
+				t.Errorf("exit code %d, want %d", code, exitValidation)
+			}
+		})
+	}
+}
+
+func TestRequiredFlagsError(t *testing.T) {
+	app := &cli.App{
+		Name:           "test",
+		Writer:         ioutil.Discard,
+		ExitErrHandler: func(c *cli.Context, err error) {},
+		Commands: []*cli.Command{{
+			Name:   "expose",
+			Flags:  []cli.Flag{&cli.StringFlag{Name: "name", Required: true}},
+			Action: func(c *cli.Context) error { return nil },
+		}},
+	}
+	err := app.Run([]string{"test", "expose"})
+	if err == nil {
+		t.Fatal("expected a missing flag error")
+	}
+	if class := getErrorClass(err); class != classValidation {
+		t.Errorf("got class %s, want %s", class, classValidation)
+	}
+}
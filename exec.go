@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// Create a vBus method definition backed by a shell command.
+// The method is declared in a node definition with the "$method" key:
+//
+//	{"reboot": {"$method": {"exec": "./reboot.sh", "params": [{"title": "delay", "type": "integer"}]}}}
+//
+// On each call, the command receives its arguments as a Json array on stdin and its
+// stdout is returned (decoded as Json when possible).
+func jsonObjToMethodDef(obj vBus.JsonObj) (*vBus.MethodDef, error) {
+	command, ok := obj["exec"].(string)
+	if !ok || strings.TrimSpace(command) == "" {
+		return nil, errors.New("'exec' must be a shell command")
+	}
+
+	params := JsonArray{}
+	if p, found := obj["params"]; found {
+		if params, ok = p.(JsonArray); !ok {
+			return nil, errors.New("'params' must be an array of Json-Schema")
+		}
+	}
+
+	returns := vBus.JsonObj{}
+	if r, found := obj["returns"]; found {
+		if returns, ok = r.(vBus.JsonObj); !ok {
+			return nil, errors.New("'returns' must be a Json-Schema")
+		}
+	}
+
+	paramsSchema := vBus.JsonObj{
+		"type":  "array",
+		"items": params,
+	}
+	return vBus.NewMethodDefWithSchema(makeExecMethod(command, len(params)), paramsSchema, returns), nil
+}
+
+// Build a method callback with the signature expected by vbus.go:
+// func(arg1, ..., argN interface{}, path []string) (interface{}, error)
+func makeExecMethod(command string, paramCount int) interface{} {
+	var in []reflect.Type
+	for i := 0; i < paramCount; i++ {
+		in = append(in, reflect.TypeOf((*interface{})(nil)).Elem())
+	}
+	in = append(in, reflect.TypeOf([]string{}))
+
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	out := []reflect.Type{reflect.TypeOf((*interface{})(nil)).Elem(), errorType}
+
+	fn := reflect.MakeFunc(reflect.FuncOf(in, out, false), func(values []reflect.Value) []reflect.Value {
+		args := make([]interface{}, paramCount)
+		for i := 0; i < paramCount; i++ {
+			args[i] = values[i].Interface()
+		}
+
+		result, err := runExecMethod(command, args)
+		resultValue := reflect.New(out[0]).Elem()
+		if result != nil {
+			resultValue.Set(reflect.ValueOf(result))
+		}
+		errValue := reflect.Zero(errorType)
+		if err != nil {
+			errValue = reflect.ValueOf(err)
+		}
+		return []reflect.Value{resultValue, errValue}
+	})
+	return fn.Interface()
+}
+
+// Run a shell command with Json args on stdin and return its output.
+func runExecMethod(command string, args []interface{}) (interface{}, error) {
+	input, err := json.Marshal(args)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encode args")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrap(err, msg)
+		}
+		return nil, err
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if len(output) == 0 {
+		return nil, nil
+	}
+	var result interface{}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return string(output), nil
+	}
+	return result, nil
+}
@@ -3,7 +3,7 @@ module github.com/veeainc/vbus-cmd
 go 1.13
 
 require (
-	github.com/Jeffail/gabs v1.4.0
+	github.com/BurntSushi/toml v0.3.1
 	github.com/c-bata/go-prompt v0.2.3
 	github.com/jeremywohl/flatten v1.0.1
 	github.com/mattn/go-runewidth v0.0.9 // indirect
@@ -17,6 +17,9 @@ require (
 	github.com/urfave/cli/v2 v2.2.0
 	github.com/veeainc/utils.go v1.3.3
 	github.com/veeainc/vbus.go v1.5.1
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/sys v0.0.0-20200519105757-fe76b779f299
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 replace github.com/veeainc/vbus.go => ../vbus.go
\ No newline at end of file
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The vbus-cmd identity is a UUID generated once and used as app name when --app is not given,
+// so the hub approves it once and its permissions are cached in its vBus config files.
+const identityFile = "vbus-cmd.id"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// A vBus client config file, as listed by 'identity list'.
+type configInfo struct {
+	File        string   `json:"file"`
+	Domain      string   `json:"domain"`
+	App         string   `json:"app"`
+	User        string   `json:"user,omitempty"`
+	Url         string   `json:"url,omitempty"`
+	Permissions []string `json:"permissions"`
+	Modified    string   `json:"modified"`
+	Identity    bool     `json:"identity"`        // config file of the vbus-cmd identity
+	Error       string   `json:"error,omitempty"` // invalid config file
+}
+
+// The vbus-cmd identity, as printed by 'identity show'.
+type identityInfo struct {
+	Uuid    string       `json:"uuid"`
+	File    string       `json:"file"`
+	Configs []configInfo `json:"configs"` // one by domain
+}
+
+// Get the identity file path, in the vBus path.
+func getIdentityPath() string {
+	return path.Join(getVbusPath(), identityFile)
+}
+
+// Generate a random (version 4) UUID.
+func newUuid() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "cannot generate UUID")
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Tells if an app name is a UUID, as generated for identities and temporary identities.
+func isUuid(app string) bool {
+	return uuidPattern.MatchString(app)
+}
+
+// Read the identity UUID, empty when there is none yet.
+func readIdentity() (string, error) {
+	buf, err := ioutil.ReadFile(getIdentityPath())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "cannot read identity")
+	}
+
+	id := strings.TrimSpace(string(buf))
+	if id == "" || strings.ContainsAny(id, ".*> \t") {
+		return "", errors.New("invalid identity file (remove it with 'identity reset'): " + getIdentityPath())
+	}
+	return id, nil
+}
+
+// Get the identity UUID, it is generated and stored on first use.
+func getIdentity() (string, error) {
+	if id, err := readIdentity(); err != nil || id != "" {
+		return id, err
+	}
+
+	id, err := newUuid()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(getVbusPath(), 0755); err != nil {
+		return "", errors.Wrap(err, "cannot create vBus path")
+	}
+
+	// write a complete temporary file then link it, so a concurrent run never reads a partial identity
+	file, err := ioutil.TempFile(getVbusPath(), identityFile+".*.tmp")
+	if err != nil {
+		return "", errors.Wrap(err, "cannot save identity")
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(id + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "cannot save identity")
+	}
+
+	err = os.Link(file.Name(), getIdentityPath())
+	if os.IsExist(err) {
+		return readIdentity() // created by a concurrent run
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "cannot save identity")
+	}
+	return id, nil
+}
+
+// Remove the identity and its config files, a new identity is generated on next connection.
+// The removed files are returned.
+func resetIdentity() ([]string, error) {
+	id, err := readIdentity()
+	if err != nil {
+		id = "" // an invalid identity file is removed anyway
+	}
+
+	var removed []string
+	if id != "" {
+		configs, err := listClientConfigs(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			if config.Identity {
+				if err := os.Remove(config.File); err != nil {
+					return removed, errors.Wrap(err, "cannot remove config file")
+				}
+				removed = append(removed, config.File)
+			}
+		}
+	}
+
+	if err := os.Remove(getIdentityPath()); err == nil {
+		removed = append(removed, getIdentityPath())
+	} else if !os.IsNotExist(err) {
+		return removed, errors.Wrap(err, "cannot remove identity")
+	}
+	return removed, nil
+}
+
+// List the vBus client config files in the vBus path, `identity` is the vbus-cmd identity UUID.
+func listClientConfigs(identity string) ([]configInfo, error) {
+	files, err := ioutil.ReadDir(getVbusPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list vBus config files")
+	}
+
+	var configs []configInfo
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".conf")
+		parts := strings.SplitN(name, ".", 2)
+		if file.IsDir() || name == file.Name() || len(parts) != 2 {
+			continue
+		}
+
+		info := configInfo{
+			File:     path.Join(getVbusPath(), file.Name()),
+			Domain:   parts[0],
+			App:      parts[1],
+			Modified: file.ModTime().Format(time.RFC3339),
+			Identity: identity != "" && parts[1] == identity,
+		}
+		if config, err := loadClientConfig(info.Domain, info.App); err != nil {
+			info.Error = err.Error()
+		} else {
+			info.User = config.Client.User
+			info.Url = config.Vbus.Url
+			info.Permissions = config.Client.Permissions.Subscribe
+		}
+		configs = append(configs, info)
+	}
+
+	sort.Slice(configs, func(i, j int) bool { return configs[i].File < configs[j].File })
+	return configs, nil
+}
+
+// Get the vbus-cmd identity with its config files.
+func getIdentityInfo() (*identityInfo, error) {
+	id, err := readIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, errors.New("no identity yet, it is created on the first run without --app")
+	}
+
+	configs, err := listClientConfigs(id)
+	if err != nil {
+		return nil, err
+	}
+	info := &identityInfo{Uuid: id, File: getIdentityPath(), Configs: []configInfo{}}
+	for _, config := range configs {
+		if config.Identity {
+			info.Configs = append(info.Configs, config)
+		}
+	}
+	return info, nil
+}
+
+// Print config files as a table.
+func printClientConfigs(configs []configInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tDOMAIN\tAPP\tURL\tPERMISSIONS\tMODIFIED")
+	for _, c := range configs {
+		mark := ""
+		if c.Identity {
+			mark = "*"
+		}
+		details := fmt.Sprintf("%s\t%d", c.Url, len(c.Permissions))
+		if c.Error != "" {
+			details = "invalid\t-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", mark, c.Domain, c.App, details, c.Modified)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// Supported input formats.
+const (
+	formatJson = "json"
+	formatYaml = "yaml"
+	formatToml = "toml"
+)
+
+// Get the --format flag shared by commands reading a value from a file.
+func inputFormatFlag() cli.Flag {
+	return &cli.StringFlag{Name: "format", Usage: "Input `FORMAT`: json, yaml or toml (default: from the file extension, else json)"}
+}
+
+// Get the input format from the --format flag value, or from the file extension.
+func getInputFormat(filename string, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case formatJson:
+		return formatJson, nil
+	case formatYaml, "yml":
+		return formatYaml, nil
+	case formatToml:
+		return formatToml, nil
+	case "":
+		// detect from extension
+	default:
+		return "", fmt.Errorf("unknown format '%s' (expected json, yaml or toml)", format)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return formatYaml, nil
+	case ".toml":
+		return formatToml, nil
+	default:
+		return formatJson, nil
+	}
+}
+
+// Read and parse an input file, "-" reads from stdin.
+func readInputFile(filename string, format string) (interface{}, error) {
+	format, err := getInputFormat(filename, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if filename == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseInput(buf, format)
+}
+
+// Parse a command line input, in Json unless another format is given.
+func parseInputArg(arg string, format string) (interface{}, error) {
+	format, err := getInputFormat("", format)
+	if err != nil {
+		return nil, err
+	}
+	return parseInput([]byte(arg), format)
+}
+
+// Parse an input in the given format.
+// Yaml and Toml inputs are converted to the values Json would give (string keys, float64
+// numbers...), so they follow the same path as Json inputs.
+func parseInput(buf []byte, format string) (interface{}, error) {
+	switch format {
+	case formatYaml:
+		var value interface{}
+		if err := yaml.Unmarshal(buf, &value); err != nil {
+			return nil, errors.Wrap(err, "yaml not valid")
+		}
+		return toJsonValue(yamlToJsonCompatible(value))
+	case formatToml:
+		var value map[string]interface{}
+		if err := toml.Unmarshal(buf, &value); err != nil {
+			return nil, errors.Wrap(err, "toml not valid")
+		}
+		return toJsonValue(value)
+	default:
+		value, err := jsonToGoErr(string(buf))
+		if err != nil {
+			return nil, errors.Wrap(err, "json not valid")
+		}
+		return value, nil
+	}
+}
+
+// Yaml maps are decoded with interface{} keys, convert them to string keys.
+func yamlToJsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			obj[fmt.Sprint(key)] = yamlToJsonCompatible(child)
+		}
+		return obj
+	case []interface{}:
+		for i, child := range v {
+			v[i] = yamlToJsonCompatible(child)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Convert a decoded value to its Json equivalent.
+func toJsonValue(value interface{}) (interface{}, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "value cannot be converted to Json")
+	}
+	return jsonToGoErr(string(buf))
+}
@@ -41,11 +41,11 @@ func getInteractiveConnection() (*vBus.Client, error) {
 	conn := vBus.NewClient(domain, appName)
 
 	if hubSerial != "" {
-		if err := conn.Connect(vBus.WithPwd(password), vBus.HubId(hubSerial)); err != nil {
+		if err := conn.Connect(vBus.WithPwd(password), vBus.HubId(hubSerial), vBus.WithPermissionSlice(profilePermissions)); err != nil {
 			return nil, err
 		}
 	} else {
-		if err := conn.Connect(); err != nil {
+		if err := conn.Connect(vBus.WithPwd(password), vBus.WithPermissionSlice(profilePermissions)); err != nil {
 			return nil, err
 		}
 	}
@@ -2,16 +2,11 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
-	"path"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/Jeffail/gabs"
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -33,36 +28,53 @@ type lf = logrus.Fields // alias
 
 func removeConfig() {
 	if deleteConfigFile == true {
-		vbusPath := os.Getenv("VBUS_PATH")
-		if vbusPath == "" {
-			vbusPath = path.Join(os.Getenv("HOME"), "vbus")
-		}
-		os.Remove(path.Join(vbusPath, domain+"."+appName+".conf"))
+		os.Remove(getClientConfigPath(domain, appName))
 	}
 }
 
-func printMsg(m *nats.Msg) {
-	logR.WithFields(lf{
-		"subject": m.Subject,
-		"data":    string(m.Data),
-		"reply":   m.Reply,
-	}).Info("vBus Message")
+func main() {
+	os.Exit(run(os.Args))
 }
 
-func main() {
+// Run vbus-cmd with command line arguments and get its exit code.
+func run(args []string) int {
 	var vbusConn *vBus.Client
+	var vbusConnErr error
 	var emptyPermission []string
 
 	logR.SetFormatter(&logrus.TextFormatter{})
 
 	// get vBus connection instance
 	getConn := func(permission []string) *vBus.Client {
-		if vbusConn == nil {
-			vbusConn = getConnection(domain, appName, password, permission, wait)
+		if vbusConn == nil && vbusConnErr == nil { // do not retry a failed connection
+			// with --loop, a failed connection fails the attempt: retries share the --loop deadline and counter
+			vbusConn, vbusConnErr = getConnection(domain, appName, password, permission, wait && !loop)
 		}
 		return vbusConn
 	}
 
+	// error returned by commands when the vBus connection failed
+	connError := func() error {
+		return connectionError(errors.Wrap(vbusConnErr, "no vBus connection"))
+	}
+
+	// get a direct Nats connection with full permission
+	getNatsConn := func() (*nats.Conn, error) {
+		// request full permission then close regular vBus connection
+		if vbusConn != nil {
+			vbusConn.Close()
+			vbusConn = nil
+		}
+		conn := getConn([]string{">"})
+		if conn == nil {
+			return nil, connError()
+		}
+		conn.Close()
+
+		// re-open the same connection but with direct nats access
+		return getNatsConnection()
+	}
+
 	app := &cli.App{
 		Name:  "vbus-cmd",
 		Usage: "send vbus commands (" + version + ")",
@@ -70,27 +82,46 @@ func main() {
 			"\n\n   Examples:" +
 			"\n   vbus-cmd discover system.zigbee" +
 			"\n   vbus-cmd -pw 01234 discover system.zigbee" +
+			"\n   vbus-cmd --profile hub1 discover system.zigbee (use a connection profile)" +
 			"\n   vbus-cmd discover -j system.zigbee (json output)" +
 			"\n   vbus-cmd discover -f system.zigbee (flattened output)" +
+			"\n   vbus-cmd discover -t 10 --depth 2 system.zigbee (wait 10s, show 2 levels)" +
+			"\n   vbus-cmd --output yaml discover system.zigbee (yaml output, see --output for other formats)" +
 			"\n   vbus-cmd attribute get -t 10 system.zigbee.[...].1026.attributes.0" +
+			"\n   vbus-cmd attribute watch --until-value true system.foobar.local.config.ready" +
 			"\n   vbus-cmd method call -t 120 system.zigbee.boolangery-ThinkPad-P1-Gen-2.controller.scan 120" +
+			"\n   vbus-cmd method call -t 120 --arg duration=120 system.zigbee.boolangery-ThinkPad-P1-Gen-2.controller.scan" +
+			"\n   vbus-cmd -p \"system.zigbee.>\" snapshot system.zigbee -o zigbee.json" +
+			"\n   vbus-cmd diff --host hub2 zigbee.json system.zigbee (compare a snapshot with the live tree of hub2)" +
+			"\n   vbus-cmd restore --dry-run --only 'controller.*' --host hub2 zigbee.json system.zigbee" +
 			"\n   vbus-cmd --app=foobar node add config \"{\\\"service_ip\\\":\\\"192.168.1.88\\\"}\"" +
 			"\n   vbus-cmd -p \"system.foobar.>\" attribute get system.foobar.local.config.service_ip" +
-			"\n   vbus-cmd --wait --domain=mydomain --app=myapp expose --name=redis --protocol=redis --port=6379",
+			"\n   vbus-cmd permission check system.foobar.local.config.service_ip (is a timeout a permission issue?)" +
+			"\n   vbus-cmd --wait --domain=mydomain --app=myapp expose --name=redis --protocol=redis --port=6379" +
+			"\n   vbus-cmd --wait --max-wait 5m --max-retries 20 attribute get system.foobar.local.config.service_ip",
 		Description: "This command line tool allow you to run vBus commands. When running for the first time, a configuration\n" +
 			"   file will be created in $HOME or $VBUS_PATH env. variable. So you need to have write access to this folder.\n" +
 			"\nENV. VARIABLES:" +
 			"\n   VBUS_PATH: the config path used to store the config file (optional)" +
-			"\n   VBUS_URL: direct nats server url (optional)",
+			"\n   VBUS_URL: direct nats server url (optional)" +
+			"\n   VBUS_CMD_CONFIG: the vbus-cmd config file with connection profiles (optional, default: $HOME/.vbus-cmd.yaml)",
 		Flags: []cli.Flag{
 			&cli.BoolFlag{Name: "debug", Aliases: []string{"d"}, Value: false, Usage: "Show vBus library logs"},
 			&cli.BoolFlag{Name: "wait", Aliases: []string{"w"}, Value: false, Destination: &wait, Usage: "Wait for vBus connection"},
 			&cli.BoolFlag{Name: "loop", Aliases: []string{"l"}, Value: false, Destination: &loop, Usage: "Loop until is successful"},
+			&cli.DurationFlag{Name: "backoff", Value: retry.initial, Destination: &retry.initial, Usage: "First delay between --wait/--loop attempts, doubled on each attempt"},
+			&cli.DurationFlag{Name: "max-backoff", Value: retry.max, Destination: &retry.max, Usage: "Maximum delay between --wait/--loop attempts"},
+			&cli.DurationFlag{Name: "max-wait", Destination: &retry.maxWait, Usage: "Give up --wait/--loop after this duration (default: no limit)"},
+			&cli.IntFlag{Name: "max-retries", Destination: &retry.maxRetries, Usage: "Give up --wait/--loop after `N` retries (default: no limit)"},
 			&cli.BoolFlag{Name: "interactive", Aliases: []string{"i"}, Value: false, Usage: "Start an interactive prompt"},
 			&cli.StringSliceFlag{Name: "permission", Aliases: []string{"p"}, Usage: "Ask a permission before running the command"},
 			&cli.StringFlag{Name: "password", Aliases: []string{"pw"}, Usage: "vBus password", Value: password, Destination: &password},
 			&cli.StringFlag{Name: "domain", Usage: "Change domain name", Value: domain, Destination: &domain},
 			&cli.StringFlag{Name: "app", Usage: "Change app name", Value: appName, Destination: &appName},
+			&cli.BoolFlag{Name: "keep-config", Usage: "Reuse the last vbus-cmd config file of the domain (e.g. of a --temporary run) instead of the identity"},
+			&cli.BoolFlag{Name: "temporary", Usage: "Use a temporary identity, its vBus config file is removed on exit (for concurrent runs asking permissions)"},
+			&cli.StringFlag{Name: "profile", Usage: "Use the connection profile `NAME` of the config file ($VBUS_CMD_CONFIG or $HOME/.vbus-cmd.yaml)"},
+			&cli.StringFlag{Name: "output", Usage: "Output `FORMAT`: json, pretty, yaml, flat, table or raw (default depends on the command)", Destination: &outputFormat},
 		},
 		Before: func(c *cli.Context) error {
 			// debug mode
@@ -100,10 +131,41 @@ func main() {
 				vBus.SetLogLevel(logrus.FatalLevel)
 			}
 
-			if appName == "new" {
-				randomValue := rand.Intn(99999999)
-				appName = strconv.Itoa(randomValue)
-				deleteConfigFile = true
+			if outputFormat != "" {
+				if err := checkOutputFormat(outputFormat); err != nil {
+					return withClass(classValidation, err)
+				}
+			}
+
+			// connection profile
+			profile, err := loadProfile(getProfileConfigPath(), c.String("profile"))
+			if err != nil {
+				return withClass(classValidation, err)
+			}
+			if profile != nil {
+				profile.apply(c)
+			}
+
+			// identity commands only use local files
+			if c.Args().First() == "identity" {
+				return nil
+			}
+
+			if appName == "new" && c.Bool("keep-config") {
+				// reuse the last vbus-cmd config file of the domain, if any
+				if appName, err = findClientConfigApp(domain); err != nil {
+					return err
+				}
+			}
+			if appName == "new" || appName == "" {
+				if c.Bool("temporary") {
+					if appName, err = newUuid(); err != nil {
+						return err
+					}
+					deleteConfigFile = true
+				} else if appName, err = getIdentity(); err != nil {
+					return err
+				}
 			}
 
 			if c.Bool("interactive") {
@@ -121,7 +183,9 @@ func main() {
 		After: func(c *cli.Context) error {
 			if vbusConn != nil {
 				vbusConn.Close()
+				vbusConn = nil // a new connection is needed with --loop
 			}
+			vbusConnErr = nil
 			removeConfig()
 			return nil
 		},
@@ -130,32 +194,38 @@ func main() {
 				Name:    "discover",
 				Aliases: []string{"d"},
 				Usage:   "Discover elements on `PATH`",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.BoolFlag{Name: "flatten", Aliases: []string{"f"}, Usage: "Display output as a flattened list"},
-					&cli.BoolFlag{Name: "list", Aliases: []string{"l"}, Usage: "Display output as a key value list"},
-				},
+					&cli.BoolFlag{Name: "list", Aliases: []string{"l"}, Usage: "Display elements as a table (path, kind, type, value and description)"},
+					&cli.StringFlag{Name: "sort", Value: "path", Usage: "Sort the --list table by `COLUMN` (path, kind, type or value)"},
+					&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Value: 2, Usage: "Discover timeout in seconds"},
+					&cli.IntFlag{Name: "depth", Value: 0, Usage: "Limit the output to `N` node levels (0 means no limit)"},
+				}, projectionFlags()...),
 				ArgsUsage: "PATH",
 				Action: func(c *cli.Context) error {
 					if c.Args().Len() != 1 {
-						return errors.New("'discover' exactly one PATH argument")
+						return validationError("'discover' exactly one PATH argument")
 					}
 
 					conn := getConn([]string{c.Args().Get(0)})
 					if conn == nil {
-						return errors.New("no vBus connection")
+						return connError()
+					}
+					if c.Int("depth") < 0 {
+						return validationError("'depth' cannot be negative")
 					}
-					if elem, err := conn.Discover(c.Args().Get(0), 2*time.Second); err != nil {
+					if elem, err := conn.Discover(c.Args().Get(0), time.Duration(c.Int("timeout"))*time.Second); err != nil {
 						return err
 					} else {
-						if c.Bool("flatten") {
-							dumpElementFlattened(elem)
-						} else if c.Bool("list") {
-							dumpElement(elem)
-						} else {
-							dumpElementToColoredJson(elem)
+						projected := c.IsSet("template") || c.IsSet("jsonpath")
+						if (c.Bool("list") || outputFormat == formatTable) && !projected {
+							return renderElementTable(os.Stdout, elem.Tree(), c.Int("depth"), c.String("sort"))
 						}
-
-						return nil
+						tree := limitTreeDepth(elem.Tree(), c.Int("depth"))
+						if c.Bool("flatten") && !projected {
+							return renderFormat(tree, formatFlat)
+						}
+						return renderProjected(c, tree, formatPretty)
 					}
 				},
 			},
@@ -169,32 +239,67 @@ func main() {
 						Aliases:     []string{"s"},
 						Usage:       "Get node on `PATH`",
 						Description: "PATH is a dot style vBus path",
-						Flags: []cli.Flag{
+						Flags: append([]cli.Flag{
 							&cli.BoolFlag{Name: "json", Aliases: []string{"j"}, Usage: "Display output as a simplified json (no method, no json-schema)"},
-						},
+						}, projectionFlags()...),
 						ArgsUsage: "PATH",
 						Action: func(c *cli.Context) error {
 							if c.Args().Len() != 1 {
-								return errors.New("'get' expect exactly one PATH argument")
+								return validationError("'get' expect exactly one PATH argument")
 							}
 
 							conn := getConn(emptyPermission)
 							if conn == nil {
-								return errors.New("no vBus connection")
+								return connError()
 							}
 
-							node := getNode(c.Args().Get(0), conn)
-							if node == nil {
-								return errors.New("Node not available")
+							node, err := getNode(c.Args().Get(0), conn)
+							if err != nil {
+								return err
 							}
 
 							if c.Bool("json") {
-								fmt.Println(goToPrettyColoredJson(node.AsNode().Json()))
-							} else {
-								dumpElementToColoredJson(node)
+								return renderProjected(c, node.AsNode().Json(), formatPretty)
+							}
+							return renderProjected(c, node.Tree(), formatPretty)
+						},
+					}, {
+						Name:        "watch",
+						Aliases:     []string{"w"},
+						Usage:       "Print add/del notifications on `PATH` as Json lines",
+						Description: "PATH is a dot style vBus path",
+						ArgsUsage:   "PATH",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "events", Aliases: []string{"e"}, Value: "add,del", Usage: "Comma separated list of notifications to watch (add, del)"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() != 1 {
+								return validationError("'watch' expect exactly one PATH argument")
 							}
 
-							return nil
+							var events []string
+							for _, event := range strings.Split(c.String("events"), ",") {
+								event = strings.TrimSpace(event)
+								if event != "add" && event != "del" {
+									return validationError("unknown event: " + event)
+								}
+								events = append(events, event)
+							}
+
+							conn := getConn(emptyPermission)
+							if conn == nil {
+								return connError()
+							}
+
+							node, err := getNode(c.Args().Get(0), conn)
+							if err != nil {
+								return err
+							}
+							if !node.IsNode() {
+								return validationError("not a node: " + node.GetPath())
+							}
+
+							return watchNode(node.AsNode(), events)
 						},
 					}, {
 						Name:        "add",
@@ -203,57 +308,68 @@ func main() {
 						Description: "UUID is a vBus path segment, it will be appended to <domain>.<app>.local",
 						ArgsUsage:   "UUID",
 						Flags: []cli.Flag{
-							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Get input from a file"},
+							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Get input from a Json, Yaml or Toml file (- for stdin)"},
+							inputFormatFlag(),
+							&cli.StringFlag{Name: "state", Aliases: []string{"s"}, Usage: "Persist remote attribute writes in `FILE` and reload them on start"},
+							&cli.BoolFlag{Name: "watch", Aliases: []string{"w"}, Usage: "Watch --file and publish its changes without reconnecting"},
 						},
 						Action: func(c *cli.Context) error {
 							// validate args
 							if c.String("file") != "" {
 								if c.Args().Len() < 1 {
-									return errors.New("'add' expect an UUID")
+									return validationError("'add' expect an UUID")
+								}
+								if c.Bool("watch") && c.String("file") == "-" {
+									return validationError("--watch cannot be used with stdin")
 								}
 							} else {
 								if c.Args().Len() < 2 {
-									return errors.New("'add' expect an UUID and a Json value for the node")
+									return validationError("'add' expect an UUID and a Json value for the node")
+								}
+								if c.Bool("watch") {
+									return validationError("--watch requires --file")
 								}
 							}
 
 							// get args
 							uuid := c.Args().Get(0)
-							input := ""
-							if c.String("file") != "" {
-								buf, err := ioutil.ReadFile(c.String("file"))
-								if err != nil {
-									log.Print(err.Error())
-									return err
-								}
-								input = string(buf)
-							} else {
-								input = strings.Join(c.Args().Slice()[1:], "")
-							}
 
 							// validate uuid
 							if strings.Contains(uuid, ".") {
-								log.Print("Not a valid node uuid: " + uuid)
-								return errors.New("Not a valid node uuid")
+								return validationError("Not a valid node uuid: " + uuid)
 							}
 
 							// validate tree
-							tree := jsonToGo(input)
-							if tree == nil {
-								return errors.New("json not valid")
+							var tree interface{}
+							var err error
+							if c.String("file") != "" {
+								tree, err = readInputFile(c.String("file"), c.String("format"))
+							} else {
+								tree, err = parseInputArg(strings.Join(c.Args().Slice()[1:], ""), c.String("format"))
+							}
+							if err != nil {
+								return withClass(classValidation, err)
+							}
+
+							// load persisted attribute values
+							var state *nodeState
+							if c.String("state") != "" {
+								if state, err = loadNodeState(c.String("state")); err != nil {
+									return withClass(classValidation, err)
+								}
 							}
 
 							// create vBus raw node
-							rawNode := jsonObjToRawDef(tree)
+							rawNode := jsonObjToRawDef(tree, nil, state)
 							if rawNode == nil {
-								return errors.New("raw node not valid")
+								return validationError("raw node not valid")
 							}
 
 							conn := getConn(emptyPermission)
 							if conn == nil {
-								return errors.New("no vBus connection")
+								return connError()
 							}
-							_, err := conn.AddNode(uuid, rawNode)
+							node, err := conn.AddNode(uuid, rawNode)
 							if err != nil {
 								log.Print(err.Error())
 								return err
@@ -261,6 +377,15 @@ func main() {
 
 							log.Println("node successfully created, do not close this app (exit with Ctrl+C)")
 
+							if c.Bool("watch") {
+								obj, ok := tree.(vBus.JsonObj)
+								if !ok {
+									return validationError("--watch expects a Json object")
+								}
+								watchNodeFile(c.String("file"), c.String("format"), &reloadableNode{conn: conn, node: node, tree: obj, state: state})
+								return nil
+							}
+
 							system.WaitForCtrlC()
 							return nil
 						},
@@ -277,44 +402,107 @@ func main() {
 						Aliases: []string{"s"},
 						Usage:   "Set `ATTR` `VALUE` (value is a Json string)",
 						Description: "PATH is a dot style vBus path" +
-							"\n	 VALUE is a Json value",
-						ArgsUsage: "PATH VALUE",
+							"\n	 VALUE is a Json value, or it can be read from a Json, Yaml or Toml file with --file",
+						ArgsUsage: "PATH [VALUE]",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "no-validate", Usage: "Do not validate the value against the attribute schema, publish it as is"},
+							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Get the value from a Json, Yaml or Toml file (- for stdin)"},
+							inputFormatFlag(),
+						},
 						Action: func(c *cli.Context) error {
+							var value interface{}
+							var err error
+							if c.String("file") != "" {
+								if c.Args().Len() != 1 {
+									return validationError("'set' expect a PATH when the value is read from a file")
+								}
+								value, err = readInputFile(c.String("file"), c.String("format"))
+							} else {
+								if c.Args().Len() != 2 {
+									return validationError("'set' expect a PATH and a Json VALUE")
+								}
+								value, err = parseInputArg(c.Args().Get(1), c.String("format"))
+							}
+							if err != nil {
+								return withClass(classValidation, errors.Wrap(err, "invalid value"))
+							}
+
 							conn := getConn(emptyPermission)
 							if conn == nil {
-								return errors.New("no vBus connection")
+								return connError()
 							}
-							attr := getAttribute(c.Args().Get(0), conn)
-							if attr == nil {
-								return errors.New("attribute not available")
+							attr, err := getAttribute(c.Args().Get(0), conn)
+							if err != nil {
+								return err
+							}
+							if c.Bool("no-validate") {
+								return setAttributeValueRaw(attr, value)
 							}
-							return attr.SetValue(jsonToGo(c.Args().Get(1)))
+							if err := validateValue(attr.Schema(), value); err != nil {
+								return err
+							}
+							return attr.SetValue(value)
 						},
 					},
 					{
 						Name:    "get",
 						Aliases: []string{"g"},
 						Usage:   "Get `ATTR` value",
-						Flags: []cli.Flag{
+						Flags: append([]cli.Flag{
 							&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Value: 1},
-						},
+						}, projectionFlags()...),
 						Action: func(c *cli.Context) error {
 							conn := getConn(emptyPermission)
 							if conn == nil {
-								return errors.New("no vBus connection")
+								return connError()
 							}
-							attr := getAttribute(c.Args().Get(0), conn)
-							if attr == nil {
-								return errors.New("attribute not available")
+							attr, err := getAttribute(c.Args().Get(0), conn)
+							if err != nil {
+								return err
 							}
 							if val, err := attr.ReadValueWithTimeout(time.Duration(c.Int("timeout")) * time.Second); err != nil {
 								return err
 							} else {
-								fmt.Println(goToJson(val))
-								return nil
+								return renderProjected(c, val, formatJson)
 							}
 						},
 					},
+					{
+						Name:    "watch",
+						Aliases: []string{"w"},
+						Usage:   "Print `ATTR` value changes as Json lines",
+						Description: "PATH is a dot style vBus path" +
+							"\n	 Each line contains the notification time, the attribute path and its new value.",
+						ArgsUsage: "PATH",
+						Flags: []cli.Flag{
+							&cli.IntFlag{Name: "count", Aliases: []string{"c"}, Usage: "Exit after `N` notifications"},
+							&cli.StringFlag{Name: "until-value", Aliases: []string{"u"}, Usage: "Exit when the attribute is set to this `JSON` value"},
+						},
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() != 1 {
+								return validationError("'watch' expect exactly one PATH argument")
+							}
+
+							var until interface{}
+							if c.IsSet("until-value") {
+								value, err := jsonToGoErr(c.String("until-value"))
+								if err != nil {
+									return withClass(classValidation, errors.Wrap(err, "invalid 'until-value'"))
+								}
+								until = value
+							}
+
+							conn := getConn(emptyPermission)
+							if conn == nil {
+								return connError()
+							}
+							attr, err := getAttribute(c.Args().Get(0), conn)
+							if err != nil {
+								return err
+							}
+							return watchAttribute(attr, c.Int("count"), until, c.IsSet("until-value"))
+						},
+					},
 				},
 			},
 			{
@@ -326,39 +514,269 @@ func main() {
 						Name:    "call",
 						Aliases: []string{"g"},
 						Usage:   "Call `METHOD` (args must be passed as a Json string)",
-						Flags: []cli.Flag{
+						Description: "PATH is a dot style vBus path" +
+							"\n	 ARGS is a Json array, or named arguments can be passed with --arg (see params titles with 'discover')" +
+							"\n	 ARGS can also be read from a Json, Yaml or Toml file with --file",
+						ArgsUsage: "PATH [ARGS]",
+						Flags: append([]cli.Flag{
 							&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Value: 1},
-						},
+							&cli.StringSliceFlag{Name: "arg", Aliases: []string{"a"}, Usage: "Named argument `TITLE=VALUE`, converted to the param type"},
+							&cli.BoolFlag{Name: "no-validate", Usage: "Do not validate args against the method params schema"},
+							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Get args from a Json, Yaml or Toml file (- for stdin)"},
+							inputFormatFlag(),
+						}, projectionFlags()...),
 						Action: func(c *cli.Context) error {
+							if c.Args().Len() < 1 {
+								return validationError("'call' expect a METHOD path")
+							}
+							sources := 0
+							for _, set := range []bool{c.Args().Len() > 1, c.IsSet("arg"), c.IsSet("file")} {
+								if set {
+									sources++
+								}
+							}
+							if sources > 1 {
+								return validationError("'call' args must be passed either as a Json array, with --arg or with --file")
+							}
+
 							conn := getConn(emptyPermission)
 							if conn == nil {
-								return errors.New("no vBus connection")
+								return connError()
 							}
-							attr := getMethod(c.Args().Get(0), conn)
-							if attr == nil {
-								return errors.New("method not available")
+							method, err := getMethod(c.Args().Get(0), conn)
+							if err != nil {
+								return err
 							}
-							args := jsonToGo(c.Args().Get(1))
 
-							if _, ok := args.([]interface{}); !ok {
-								// try to wrap args as a json array
-								args = jsonToGo("[" + c.Args().Get(1) + "]")
+							args := []interface{}{}
+							if c.IsSet("arg") {
+								named, err := namedArgsToPositional(method.ParamsSchema(), c.StringSlice("arg"))
+								if err != nil {
+									return withClass(classValidation, err)
+								}
+								args = named
+							} else if c.IsSet("file") || c.IsSet("format") && c.Args().Len() > 1 {
+								var parsed interface{}
+								var err error
+								if c.IsSet("file") {
+									parsed, err = readInputFile(c.String("file"), c.String("format"))
+								} else {
+									parsed, err = parseInputArg(strings.Join(c.Args().Slice()[1:], " "), c.String("format"))
+								}
+								if err != nil {
+									return withClass(classValidation, errors.Wrap(err, "invalid method args"))
+								}
+								if array, ok := parsed.([]interface{}); ok {
+									args = array
+								} else {
+									args = []interface{}{parsed} // a single argument
+								}
+							} else if c.Args().Len() > 1 {
+								input := strings.Join(c.Args().Slice()[1:], " ")
+								parsed, err := jsonToGoErr(input)
+								if _, ok := parsed.([]interface{}); err != nil || !ok {
+									// try to wrap args as a json array
+									parsed, err = jsonToGoErr("[" + input + "]")
+									if err != nil {
+										return withClass(classValidation, errors.Wrap(err, "method args must be passed as a json array"))
+									}
+								}
+								args = parsed.([]interface{})
 							}
-							if casted, ok := args.([]interface{}); !ok {
-								return errors.New("method args must be passed as a json array")
-							} else {
-								if val, err := attr.CallWithTimeout(time.Duration(c.Int("timeout"))*time.Second, casted...); err != nil {
+
+							if !c.Bool("no-validate") {
+								if err := validateMethodArgs(method.ParamsSchema(), args); err != nil {
 									return err
-								} else {
-									fmt.Println(goToJson(val))
-									return nil
 								}
 							}
-							return nil
+
+							if val, err := method.CallWithTimeout(time.Duration(c.Int("timeout"))*time.Second, args...); err != nil {
+								return err
+							} else {
+								return renderProjected(c, val, formatJson)
+							}
 						},
 					},
 				},
 			},
+			{
+				Name:  "snapshot",
+				Usage: "Save the tree on `PATH` with live attribute values",
+				Description: "PATH is a dot style vBus path" +
+					"\n	 The snapshot is a Json document with schemas, attribute values and method signatures.",
+				ArgsUsage: "PATH",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "out", Aliases: []string{"o"}, Usage: "Write the snapshot to `FILE` (default: stdout)"},
+					&cli.StringFlag{Name: "host", Usage: "Snapshot the hub `HOSTNAME` when several hubs answer on PATH (local for this host)"},
+					&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Value: 2, Usage: "Discover timeout in seconds"},
+					&cli.DurationFlag{Name: "read-timeout", Value: time.Second, Usage: "Timeout of each attribute read"},
+					&cli.IntFlag{Name: "workers", Value: 8, Usage: "Read `N` attributes concurrently"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return validationError("'snapshot' expect exactly one PATH argument")
+					}
+					if c.Int("workers") < 1 {
+						return validationError("'workers' must be at least 1")
+					}
+
+					conn := getConn([]string{c.Args().Get(0)})
+					if conn == nil {
+						return connError()
+					}
+					node, host, err := discoverHostNode(conn, c.Args().Get(0), c.String("host"), time.Duration(c.Int("timeout"))*time.Second)
+					if err != nil {
+						return err
+					}
+
+					s := takeSnapshot(node, c.Args().Get(0), host, c.Duration("read-timeout"), c.Int("workers"))
+					if c.String("out") == "" {
+						return renderOutput(s, formatPretty)
+					}
+					if err := writeSnapshotFile(c.String("out"), s); err != nil {
+						return err
+					}
+					logR.WithFields(lf{"file": c.String("out"), "elements": len(s.Elements)}).Info("snapshot saved")
+					return nil
+				},
+			},
+			{
+				Name:  "diff",
+				Usage: "Compare two trees, each side is a vBus `PATH` or a snapshot file",
+				Description: "A and B are dot style vBus paths or files written by 'snapshot'" +
+					"\n	 Added, removed and changed elements and values are listed, then schema changes." +
+					"\n	 Use --output json (or yaml) for a machine-readable list of changes.",
+				ArgsUsage: "A B",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Value: 2, Usage: "Discover timeout in seconds"},
+					&cli.DurationFlag{Name: "read-timeout", Value: time.Second, Usage: "Timeout of each attribute read"},
+					&cli.IntFlag{Name: "workers", Value: 8, Usage: "Read `N` attributes concurrently"},
+					&cli.BoolFlag{Name: "exit-code", Usage: "Exit with status 8 when there are differences"},
+					&cli.StringSliceFlag{Name: "host", Usage: "Hub `HOSTNAME` of each vBus path side, in order, when several hubs answer (local for this host)"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 2 {
+						return validationError("'diff' expect two arguments (vBus paths or snapshot files)")
+					}
+					if c.Int("workers") < 1 {
+						return validationError("'workers' must be at least 1")
+					}
+
+					var sides []*snapshot
+					hosts := c.StringSlice("host")
+					for _, side := range c.Args().Slice() {
+						if isSnapshotFile(side) {
+							s, err := readSnapshotFile(side)
+							if err != nil {
+								return withClass(classValidation, err)
+							}
+							sides = append(sides, s)
+							continue
+						}
+
+						conn := getConn([]string{side})
+						if conn == nil {
+							return connError()
+						}
+						host := ""
+						if len(hosts) > 0 {
+							host, hosts = hosts[0], hosts[1:]
+						}
+						node, host, err := discoverHostNode(conn, side, host, time.Duration(c.Int("timeout"))*time.Second)
+						if err != nil {
+							return err
+						}
+						sides = append(sides, takeSnapshot(node, side, host, c.Duration("read-timeout"), c.Int("workers")))
+					}
+					if len(hosts) > 0 {
+						return validationError("more --host values than vBus path sides")
+					}
+
+					entries := diffSnapshots(sides[0], sides[1])
+					if outputFormat != "" {
+						if entries == nil {
+							entries = []diffEntry{} // print [] rather than null
+						}
+						if err := renderOutput(entries, formatJson); err != nil {
+							return err
+						}
+					} else if len(entries) == 0 {
+						fmt.Println("no differences")
+					} else {
+						printDiff(entries)
+						fmt.Println()
+						fmt.Println(strings.Join(countDiffChanges(entries), ", "))
+					}
+
+					if c.Bool("exit-code") && len(entries) > 0 {
+						return cli.Exit("", exitDifferences)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "Write the attribute values of a snapshot `FILE` on `PATH`",
+				Description: "FILE is a snapshot written by 'snapshot', PATH is a dot style vBus path" +
+					"\n	 Attributes missing on PATH, read-only or with an invalid value are skipped with a warning." +
+					"\n	 --only and --exclude globs apply to paths relative to the hub (e.g. 'controller.*')",
+				ArgsUsage: "FILE PATH",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "dry-run", Aliases: []string{"n"}, Usage: "Print the planned writes without writing"},
+					&cli.StringSliceFlag{Name: "only", Usage: "Only restore attributes matching `GLOB`"},
+					&cli.StringSliceFlag{Name: "exclude", Aliases: []string{"x"}, Usage: "Do not restore attributes matching `GLOB`"},
+					&cli.IntFlag{Name: "timeout", Aliases: []string{"t"}, Value: 2, Usage: "Discover timeout in seconds"},
+					&cli.DurationFlag{Name: "read-timeout", Value: time.Second, Usage: "Timeout of each current value read"},
+					&cli.StringFlag{Name: "host", Usage: "Restore on the hub `HOSTNAME` when several hubs answer on PATH (local for this host)"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 2 {
+						return validationError("'restore' expect a snapshot FILE and a PATH")
+					}
+					filter := restoreFilter{only: c.StringSlice("only"), exclude: c.StringSlice("exclude")}
+					if err := filter.validate(); err != nil {
+						return withClass(classValidation, err)
+					}
+
+					s, err := readSnapshotFile(c.Args().Get(0))
+					if err != nil {
+						return withClass(classValidation, err)
+					}
+
+					target := c.Args().Get(1)
+					conn := getConn([]string{target})
+					if conn == nil {
+						return connError()
+					}
+					node, _, err := discoverHostNode(conn, target, c.String("host"), time.Duration(c.Int("timeout"))*time.Second)
+					if err != nil {
+						return err
+					}
+
+					results := restoreSnapshot(s, node, filter, c.Bool("dry-run"), c.Duration("read-timeout"))
+					if outputFormat != "" {
+						if results == nil {
+							results = []restoreResult{} // print [] rather than null
+						}
+						if err := renderOutput(results, formatJson); err != nil {
+							return err
+						}
+					} else {
+						printRestoreReport(results)
+					}
+
+					failed := 0
+					for _, r := range results {
+						if r.Status == restoreFailed {
+							failed++
+						}
+					}
+					if failed > 0 {
+						return fmt.Errorf("%d attribute writes failed", failed)
+					}
+					return nil
+				},
+			},
 			{
 				Name:    "expose",
 				Aliases: []string{"e"},
@@ -375,7 +793,7 @@ func main() {
 				Action: func(c *cli.Context) error {
 					conn := getConn(emptyPermission)
 					if conn == nil {
-						return errors.New("no vBus connection")
+						return connError()
 					}
 					if err := conn.Expose(c.String("name"), c.String("protocol"), c.Int("port"), c.String("path")); err != nil {
 						return err
@@ -391,46 +809,89 @@ func main() {
 				Name:    "spy",
 				Aliases: []string{"s"},
 				Usage:   "spy pub/sub messages",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{Name: "subject", Aliases: []string{"s"}, Usage: "Only show subjects matching this `PATTERN` (Nats wildcards allowed)"},
+					&cli.StringSliceFlag{Name: "exclude", Aliases: []string{"x"}, Usage: "Hide subjects matching this `PATTERN` (Nats wildcards allowed)"},
+					&cli.BoolFlag{Name: "json", Aliases: []string{"j"}, Usage: "Display messages as Json lines, payloads are decoded when possible"},
+					&cli.IntFlag{Name: "max", Aliases: []string{"m"}, Usage: "Exit after `N` messages"},
+					&cli.DurationFlag{Name: "duration", Aliases: []string{"t"}, Usage: "Exit after this `DURATION` (i.e. 30s, 5m)"},
+					&cli.StringFlag{Name: "record", Aliases: []string{"r"}, Usage: "Record displayed messages to `FILE` (see 'replay' command)"},
+					&cli.BoolFlag{Name: "correlate", Aliases: []string{"c"}, Usage: "Display requests paired with their reply and latency"},
+					&cli.DurationFlag{Name: "reply-window", Value: 5 * time.Second, Usage: "With --correlate, flag requests without reply after this `DURATION`"},
+					&cli.BoolFlag{Name: "stats", Usage: "Display traffic statistics by subject prefix instead of messages"},
+					&cli.IntFlag{Name: "stats-depth", Value: 3, Usage: "With --stats, aggregate subjects on their first `N` tokens"},
+					&cli.IntFlag{Name: "stats-top", Value: 20, Usage: "With --stats, display the `N` most active prefixes (0 means all)"},
+					&cli.DurationFlag{Name: "stats-interval", Value: 2 * time.Second, Usage: "With --stats, refresh statistics every `DURATION`"},
+				},
 				Action: func(c *cli.Context) error {
-					// request full permission then close regular vBus connection
-					if vbusConn != nil {
-						vbusConn.Close()
-						vbusConn = nil
+					if c.Duration("reply-window") <= 0 {
+						return validationError("'reply-window' must be a positive duration")
 					}
-					conn := getConn([]string{">"})
-					if conn == nil {
-						return errors.New("no vBus connection")
+					if c.Bool("stats") && c.Bool("correlate") {
+						return validationError("'stats' and 'correlate' cannot be used together")
 					}
-					conn.Close()
-
-					// re-open the same connection but with direct nats access
-					vbusPath := os.Getenv("VBUS_PATH")
-					if vbusPath == "" {
-						vbusPath = path.Join(os.Getenv("HOME"), "vbus")
+					if c.Int("stats-depth") < 1 {
+						return validationError("'stats-depth' must be at least 1")
+					}
+					if c.Duration("stats-interval") <= 0 {
+						return validationError("'stats-interval' must be a positive duration")
+					}
+					if err := validateSubjectPatterns(append(c.StringSlice("subject"), c.StringSlice("exclude")...)); err != nil {
+						return err
 					}
-					confFile := path.Join(vbusPath, domain+"."+appName+".conf")
-					file, _ := ioutil.ReadFile(confFile)
-					clientConfig, _ := gabs.ParseJSON([]byte(file))
 
-					client, err := nats.Connect(clientConfig.Search("vbus", "url").Data().(string), nats.UserInfo(clientConfig.Search("client", "user").Data().(string), clientConfig.Search("key", "private").Data().(string)))
+					client, err := getNatsConn()
 					if err != nil {
 						return err
 					}
 					defer client.Close()
 
-					// Subscribe to everything
-					if _, err := client.Subscribe(">", func(m *nats.Msg) {
-						printMsg(m)
-					}); err != nil {
-						logR.WithFields(lf{
-							"error": err.Error(),
-						}).Error("cannot subscribe spi")
+					return runSpy(client, spyOptions{
+						subjects: c.StringSlice("subject"),
+						excludes: c.StringSlice("exclude"),
+						json:     c.Bool("json") || outputFormat != "",
+						max:      c.Int("max"),
+						duration: c.Duration("duration"),
+						record:   c.String("record"),
+
+						correlate:   c.Bool("correlate"),
+						replyWindow: c.Duration("reply-window"),
+
+						stats:         c.Bool("stats"),
+						statsDepth:    c.Int("stats-depth"),
+						statsTop:      c.Int("stats-top"),
+						statsInterval: c.Duration("stats-interval"),
+					})
+				},
+			},
+			{
+				Name:      "replay",
+				Usage:     "Publish messages recorded with 'spy --record'",
+				ArgsUsage: "FILE",
+				Flags: []cli.Flag{
+					&cli.Float64Flag{Name: "speed", Value: 1, Usage: "Timing scale factor (2 is twice faster, 0 means no delay)"},
+					&cli.StringSliceFlag{Name: "rewrite", Aliases: []string{"r"}, Usage: "Replace `FROM=TO` in every subject (i.e. a hub hostname)"},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return validationError("'replay' expect exactly one FILE argument")
+					}
+					if c.Float64("speed") < 0 {
+						return validationError("'speed' cannot be negative")
+					}
+
+					records, err := readCaptureFile(c.Args().Get(0))
+					if err != nil {
+						return withClass(classValidation, err)
+					}
+
+					client, err := getNatsConn()
+					if err != nil {
 						return err
 					}
+					defer client.Close()
 
-					log.Println("spi started (exit with Ctrl+C)")
-					system.WaitForCtrlC()
-					return nil
+					return replayCapture(client, records, c.Float64("speed"), c.StringSlice("rewrite"))
 				},
 			},
 			{
@@ -444,7 +905,7 @@ func main() {
 						Action: func(c *cli.Context) error {
 							conn := getConn(emptyPermission)
 							if conn == nil {
-								return errors.New("no vBus connection")
+								return connError()
 							}
 							IPaddress, err := conn.GetNetworkIP()
 
@@ -455,7 +916,144 @@ func main() {
 								return err
 							}
 
-							fmt.Println(IPaddress)
+							return renderOutput(IPaddress, formatRaw)
+						},
+					},
+				},
+			},
+			{
+				Name:  "permission",
+				Usage: "Ask, list and check the vBus permissions of the identity",
+				Description: "Permissions are Nats subject patterns ('*' matches one token, '>' the remaining tokens)," +
+					"\n	 a request on a path without permission times out.",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "ask",
+						Usage:     "Ask permissions on `PATTERN`s",
+						ArgsUsage: "PATTERN...",
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() == 0 {
+								return validationError("'ask' expect at least one PATTERN")
+							}
+							if err := validatePermissionPatterns(c.Args().Slice()); err != nil {
+								return err
+							}
+
+							conn := getConn(emptyPermission)
+							if conn == nil {
+								return connError()
+							}
+							for _, pattern := range c.Args().Slice() {
+								if err := askPermission(sanitizePath(pattern, conn), conn); err != nil {
+									return err
+								}
+								logR.WithFields(lf{"pattern": pattern}).Info("permission granted")
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the permissions held by the identity (cached in its vBus config file)",
+						Action: func(c *cli.Context) error {
+							permissions, err := loadPermissions(domain, appName)
+							if err != nil {
+								return err
+							}
+							if outputFormat != "" {
+								return renderOutput(permissions, formatJson)
+							}
+							printPermissions(permissions)
+							return nil
+						},
+					},
+					{
+						Name:      "check",
+						Usage:     "Tell if the identity is allowed to use `PATH`",
+						ArgsUsage: "PATH",
+						Action: func(c *cli.Context) error {
+							if c.Args().Len() != 1 {
+								return validationError("'check' expect exactly one PATH argument")
+							}
+							if badSubject(c.Args().Get(0)) {
+								return validationError("invalid vBus path: " + c.Args().Get(0))
+							}
+
+							conn := getConn(emptyPermission)
+							if conn == nil {
+								return connError()
+							}
+							permissions, err := loadPermissions(domain, appName)
+							if err != nil {
+								return err
+							}
+
+							result := permissions.check(sanitizePath(c.Args().Get(0), conn))
+							if outputFormat != "" {
+								if err := renderOutput(result, formatJson); err != nil {
+									return err
+								}
+							} else {
+								printPermissionCheck(result)
+							}
+							if !result.allowed() {
+								return withClass(classPermission, errors.New("not allowed: "+result.Path+" (see 'permission ask')"))
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "identity",
+				Usage: "Manage the vbus-cmd identity and the vBus config files",
+				Description: "Without --app, vbus-cmd connects with an identity generated once and stored in $VBUS_PATH," +
+					"\n	 so the hub approves it once and its permissions are cached in its config file.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "show",
+						Usage: "Show the identity UUID and its config files",
+						Action: func(c *cli.Context) error {
+							info, err := getIdentityInfo()
+							if err != nil {
+								return err
+							}
+							return renderOutput(info, formatPretty)
+						},
+					},
+					{
+						Name:  "reset",
+						Usage: "Remove the identity and its config files, a new one is generated on next run",
+						Action: func(c *cli.Context) error {
+							removed, err := resetIdentity()
+							for _, file := range removed {
+								logR.WithFields(lf{"file": file}).Info("removed")
+							}
+							if err == nil && len(removed) == 0 {
+								logR.Info("no identity to reset")
+							}
+							return err
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the vBus config files of $VBUS_PATH (* marks the identity)",
+						Action: func(c *cli.Context) error {
+							id, err := readIdentity()
+							if err != nil {
+								return err
+							}
+							configs, err := listClientConfigs(id)
+							if err != nil {
+								return err
+							}
+							if outputFormat != "" {
+								if configs == nil {
+									configs = []configInfo{} // print [] rather than null
+								}
+								return renderOutput(configs, formatJson)
+							}
+							printClientConfigs(configs)
 							return nil
 						},
 					},
@@ -466,28 +1064,37 @@ func main() {
 				Aliases: []string{"v"},
 				Usage:   "Display version number",
 				Action: func(context *cli.Context) error {
-					fmt.Println(version)
-					return nil
+					return renderOutput(version, formatRaw)
 				},
 			},
 		},
 	}
 
 	app.EnableBashCompletion = true
-	err := errors.New("fake")
-	for err != nil {
-		err = app.Run(os.Args)
-		if err != nil {
-			if loop == true {
-				log.Print(err)
-				log.Print("loop until success ....")
-			} else {
-				log.Fatal(err)
-			}
-		}
+	app.ExitErrHandler = func(c *cli.Context, err error) {} // exit codes are handled below, after --loop retries
+	setUsageErrorHandler(app)
+
+	start := time.Now()
+	err := app.Run(args)
+	if err != nil && loop && getErrorClass(err) != classValidation { // retrying cannot fix invalid arguments
+		err = retry.retryAfter("command", start, err, func() error {
+			return app.Run(args)
+		})
 	}
 
 	if vbusConn != nil {
 		vbusConn.Close()
 	}
+
+	if err != nil {
+		code := getExitCode(err)
+		if msg := err.Error(); msg != "" {
+			log.Print(msg)
+			if outputFormat == formatJson {
+				printJsonError(err, code)
+			}
+		}
+		return code
+	}
+	return 0
 }
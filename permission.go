@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// The permissions of a vBus client, as cached in its config file.
+type permissionSet struct {
+	Subscribe []string `json:"subscribe"`
+	Publish   []string `json:"publish"`
+}
+
+// The result of 'permission check': the first pattern allowing each action, if any.
+type permissionCheck struct {
+	Path      string `json:"path"`
+	Subscribe string `json:"subscribe,omitempty"`
+	Publish   string `json:"publish,omitempty"`
+}
+
+// Check permission patterns before asking them.
+func validatePermissionPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if badSubject(pattern) {
+			return validationError("invalid permission pattern: " + pattern)
+		}
+	}
+	return nil
+}
+
+// Load the permissions of a vBus client from its config file.
+func loadPermissions(domain, app string) (*permissionSet, error) {
+	config, err := loadClientConfig(domain, app)
+	if err != nil {
+		return nil, err
+	}
+	return &permissionSet{
+		Subscribe: config.Client.Permissions.Subscribe,
+		Publish:   config.Client.Permissions.Publish,
+	}, nil
+}
+
+// Tells which patterns allow to subscribe and publish on a path.
+func (p *permissionSet) check(path string) permissionCheck {
+	return permissionCheck{
+		Path:      path,
+		Subscribe: findMatchingPattern(p.Subscribe, path),
+		Publish:   findMatchingPattern(p.Publish, path),
+	}
+}
+
+// Tells if both subscribe and publish are allowed, as needed by vBus requests.
+func (c permissionCheck) allowed() bool {
+	return c.Subscribe != "" && c.Publish != ""
+}
+
+// Get the first pattern matching a subject, empty when there is none.
+func findMatchingPattern(patterns []string, subject string) string {
+	for _, pattern := range patterns {
+		if subjectMatch(pattern, subject) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Print permissions as a table.
+func printPermissions(p *permissionSet) {
+	subscribe := make(map[string]bool)
+	publish := make(map[string]bool)
+	var patterns []string
+	for _, pattern := range p.Subscribe {
+		if !subscribe[pattern] && !publish[pattern] {
+			patterns = append(patterns, pattern)
+		}
+		subscribe[pattern] = true
+	}
+	for _, pattern := range p.Publish {
+		if !subscribe[pattern] && !publish[pattern] {
+			patterns = append(patterns, pattern)
+		}
+		publish[pattern] = true
+	}
+	sort.Strings(patterns)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATTERN\tSUBSCRIBE\tPUBLISH")
+	for _, pattern := range patterns {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", pattern, yesNo(subscribe[pattern]), yesNo(publish[pattern]))
+	}
+	_ = tw.Flush()
+}
+
+// Print a permission check as a table.
+func printPermissionCheck(c permissionCheck) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tALLOWED\tPATTERN")
+	fmt.Fprintf(tw, "subscribe\t%s\t%s\n", yesNo(c.Subscribe != ""), c.Subscribe)
+	fmt.Fprintf(tw, "publish\t%s\t%s\n", yesNo(c.Publish != ""), c.Publish)
+	_ = tw.Flush()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
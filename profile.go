@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// Default permissions of the selected profile, asked in addition to --permission.
+var profilePermissions []string
+
+// Connection parameters of a hub, stored in the vbus-cmd config file.
+type connectionProfile struct {
+	Url         string   `json:"url"`         // hub url, e.g. nats://192.168.1.10:21400
+	Serial      string   `json:"serial"`      // hub serial number
+	Password    string   `json:"password"`    // vBus password
+	Domain      string   `json:"domain"`      // domain name
+	App         string   `json:"app"`         // app name
+	Permissions []string `json:"permissions"` // permissions asked on connection
+}
+
+// The vbus-cmd config file.
+type profileConfig struct {
+	Default  string                        `json:"default"` // profile used without --profile
+	Profiles map[string]*connectionProfile `json:"profiles"`
+}
+
+// Get the vbus-cmd config file path, from VBUS_CMD_CONFIG or in $HOME.
+func getProfileConfigPath() string {
+	if filename := os.Getenv("VBUS_CMD_CONFIG"); filename != "" {
+		return filename
+	}
+	return path.Join(os.Getenv("HOME"), ".vbus-cmd.yaml")
+}
+
+// Load a profile from the config file (Json, Yaml or Toml).
+// When `name` is empty, the default profile is returned, or nil if there is none.
+func loadProfile(filename string, name string) (*connectionProfile, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if name != "" {
+			return nil, errors.New("cannot use profile '" + name + "', no config file: " + filename)
+		}
+		return nil, nil
+	}
+
+	value, err := readInputFile(filename, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read config file "+filename)
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read config file "+filename)
+	}
+	var config profileConfig
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return nil, errors.Wrap(err, "invalid config file "+filename)
+	}
+
+	if name == "" {
+		name = config.Default
+		if name == "" {
+			return nil, nil
+		}
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok || profile == nil {
+		var names []string
+		for n := range config.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown profile '%s' in %s (available: %s)", name, filename, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// Apply a profile on connection parameters. Command line flags take precedence over the profile,
+// and the profile url takes precedence over the VBUS_URL env. variable.
+func (p *connectionProfile) apply(c *cli.Context) {
+	if p.Url != "" {
+		_ = os.Setenv("VBUS_URL", p.Url)
+	}
+	if p.Serial != "" {
+		hubSerial = p.Serial
+	}
+	if p.Password != "" && !c.IsSet("password") {
+		password = p.Password
+	}
+	if p.Domain != "" && !c.IsSet("domain") {
+		domain = p.Domain
+	}
+	if p.App != "" && !c.IsSet("app") {
+		appName = p.App
+	}
+	profilePermissions = p.Permissions
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// Get the flags used to project a command output.
+func projectionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "template", Usage: "Print the output with a Go `TEMPLATE` (e.g. '{{.volume.value}}')"},
+		&cli.StringFlag{Name: "jsonpath", Usage: "Print the values selected by a JSONPath `EXPR` (e.g. '$..attributes[*].value')"},
+	}
+}
+
+// Print a command output, projected with --template or --jsonpath when set.
+func renderProjected(c *cli.Context, value interface{}, defaultFormat string) error {
+	if c.IsSet("template") && c.IsSet("jsonpath") {
+		return validationError("--template and --jsonpath cannot be used together")
+	}
+
+	if c.IsSet("template") {
+		return renderTemplate(c.String("template"), value)
+	}
+	if c.IsSet("jsonpath") {
+		selected, err := evalJsonPath(c.String("jsonpath"), value)
+		if err != nil {
+			return withClass(classValidation, err)
+		}
+		return renderOutput(selected, formatJson)
+	}
+	return renderOutput(value, defaultFormat)
+}
+
+// Execute a Go template on a value and print the result.
+// The value is converted to its Json form, so templates use Json keys.
+func renderTemplate(text string, value interface{}) error {
+	tmpl, err := template.New("output").
+		Funcs(template.FuncMap{"json": goToJson}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return withClass(classValidation, errors.Wrap(err, "invalid template"))
+	}
+
+	value, err = toJsonValue(value)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, value); err != nil {
+		return errors.Wrap(err, "cannot execute template")
+	}
+	if !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+	_, err = fmt.Fprint(os.Stdout, out.String())
+	return err
+}
+
+// A JSONPath step, e.g. `.name`, `[0]`, `[*]` or `..name`.
+type jsonPathStep struct {
+	recursive bool   // '..' descendant step
+	wildcard  bool   // '*' selects every child
+	key       string // object key
+	index     int    // array index (when isIndex)
+	isIndex   bool
+}
+
+// Parse a JSONPath expression. The leading '$' is optional.
+// Supported: `.key`, `['key']`, `[n]` (negative from the end), `.*`, `[*]` and `..` recursive descent.
+func parseJsonPath(original string) ([]jsonPathStep, error) {
+	expr := strings.TrimSpace(original)
+	if strings.HasPrefix(expr, "$") {
+		expr = expr[1:]
+	} else if expr != "" && expr[0] != '.' && expr[0] != '[' {
+		expr = "." + expr
+	}
+
+	var steps []jsonPathStep
+	for i := 0; i < len(expr); {
+		step := jsonPathStep{}
+		switch {
+		case strings.HasPrefix(expr[i:], ".."):
+			step.recursive = true
+			i += 2
+		case expr[i] == '.':
+			i++
+		case expr[i] == '[':
+			// bracket selector, handled below
+		default:
+			return nil, fmt.Errorf("invalid JSONPath '%s'", original)
+		}
+
+		if i >= len(expr) {
+			return nil, fmt.Errorf("invalid JSONPath '%s': missing selector at the end", original)
+		}
+
+		if expr[i] == '[' {
+			// a quoted key may contain ']', search the closing bracket after its closing quote
+			start := 0
+			if quote := strings.TrimLeft(expr[i+1:], " "); quote != "" && (quote[0] == '\'' || quote[0] == '"') {
+				open := len(expr[i:]) - len(quote)
+				closing := strings.IndexByte(expr[i+open+1:], quote[0])
+				if closing < 0 {
+					return nil, fmt.Errorf("invalid JSONPath '%s': missing closing quote", original)
+				}
+				start = open + 1 + closing + 1
+			}
+			end := strings.IndexByte(expr[i+start:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath '%s': missing ']'", original)
+			}
+			end += start
+			selector := strings.TrimSpace(expr[i+1 : i+end])
+			i += end + 1
+
+			switch {
+			case selector == "*":
+				step.wildcard = true
+			case len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') && selector[len(selector)-1] == selector[0]:
+				step.key = selector[1 : len(selector)-1]
+			default:
+				n, err := strconv.Atoi(selector)
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSONPath selector '[%s]'", selector)
+				}
+				step.index = n
+				step.isIndex = true
+			}
+		} else {
+			end := strings.IndexAny(expr[i:], ".[")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			name := expr[i : i+end]
+			i += end
+
+			if name == "" {
+				return nil, fmt.Errorf("invalid JSONPath '%s': empty key", original)
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.key = name
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Evaluate a JSONPath expression on a value.
+// A definite path (no wildcard or recursive descent) gives the selected value itself,
+// other paths give the list of selected values.
+func evalJsonPath(expr string, value interface{}) (interface{}, error) {
+	steps, err := parseJsonPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err = toJsonValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	definite := true
+	nodes := []interface{}{value}
+	for _, step := range steps {
+		if step.recursive || step.wildcard {
+			definite = false
+		}
+
+		var next []interface{}
+		for _, node := range nodes {
+			candidates := []interface{}{node}
+			if step.recursive {
+				candidates = append(candidates, descendants(node)...)
+			}
+			for _, candidate := range candidates {
+				next = append(next, step.selectFrom(candidate)...)
+			}
+		}
+		nodes = next
+	}
+
+	if definite {
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("JSONPath '%s' does not match anything", expr)
+		}
+		return nodes[0], nil
+	}
+	if nodes == nil {
+		nodes = []interface{}{} // print [] rather than null
+	}
+	return nodes, nil
+}
+
+// Get the children of a value selected by a step.
+func (s jsonPathStep) selectFrom(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			return children(v)
+		}
+		if child, ok := v[s.key]; ok && !s.isIndex {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		if s.wildcard {
+			return v
+		}
+		if s.isIndex {
+			i := s.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return []interface{}{v[i]}
+			}
+		}
+	}
+	return nil
+}
+
+// Get the direct children of a value, object children are sorted by key.
+func children(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var list []interface{}
+		for _, k := range sortedKeys(v) {
+			list = append(list, v[k])
+		}
+		return list
+	case []interface{}:
+		return v
+	}
+	return nil
+}
+
+// Get all descendants of a value, depth first.
+func descendants(value interface{}) []interface{} {
+	var list []interface{}
+	for _, child := range children(value) {
+		list = append(list, child)
+		list = append(list, descendants(child)...)
+	}
+	return list
+}
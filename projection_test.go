@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvalJsonPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"name": "hub1",
+		"devices": []interface{}{
+			map[string]interface{}{"name": "lamp", "level": 10},
+			map[string]interface{}{"name": "plug", "level": 9},
+		},
+		"config": map[string]interface{}{
+			"dot.key": true,
+			"nested":  map[string]interface{}{"name": "inner"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"key", "$.name", "hub1"},
+		{"key without $", "name", "hub1"},
+		{"nested key", "config.nested.name", "inner"},
+		{"quoted key", "$.config['dot.key']", true},
+		{"double quoted key", `$.config["dot.key"]`, true},
+		{"index", "$.devices[1].name", "plug"},
+		{"negative index", "$.devices[-1].name", "plug"},
+		{"root", "$", doc},
+		{"wildcard on array", "$.devices[*].name", []interface{}{"lamp", "plug"}},
+		{"wildcard on object, sorted by key", "$.config.*", []interface{}{true, map[string]interface{}{"name": "inner"}}},
+		{"recursive descent", "$..name", []interface{}{"hub1", "inner", "lamp", "plug"}},
+		{"recursive descent with index", "$..devices[0].level", []interface{}{float64(10)}},
+		{"recursive descent without match", "$..missing", []interface{}{}},
+		{"wildcard on scalar", "$.name.*", []interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalJsonPath(tt.expr, doc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, _ := toJsonValue(tt.want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestEvalJsonPathTrickyCases(t *testing.T) {
+	doc := map[string]interface{}{
+		"matrix": []interface{}{
+			[]interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}},
+			[]interface{}{map[string]interface{}{"id": "c"}},
+		},
+		"config": map[string]interface{}{
+			"b":       2,
+			"a":       1,
+			"dot.key": map[string]interface{}{"id": "d"},
+			"a]b":     "bracket",
+		},
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		{"recursive descent over nested arrays", "$.matrix..id", []interface{}{"a", "b", "c"}},
+		{"recursive descent then index on arrays", "$.matrix..[0]", []interface{}{
+			[]interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}},
+			map[string]interface{}{"id": "a"},
+			map[string]interface{}{"id": "c"},
+		}},
+		{"bracket wildcard on object, sorted by key", "$.config[*]", []interface{}{1, "bracket", 2, map[string]interface{}{"id": "d"}}},
+		{"quoted key with dots", "$.config['dot.key'].id", "d"},
+		{"quoted key with dots after recursive descent", "$..['dot.key'].id", []interface{}{"d"}},
+		{"quoted key with a bracket", "$.config['a]b']", "bracket"},
+		{"spaces around a quoted key", `$.config[ "dot.key" ]`, map[string]interface{}{"id": "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evalJsonPath(tt.expr, doc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want, _ := toJsonValue(tt.want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestEvalJsonPathErrors(t *testing.T) {
+	doc := map[string]interface{}{"devices": []interface{}{"lamp"}}
+
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{"missing key", "$.missing", "does not match"},
+		{"index out of range", "$.devices[3]", "does not match"},
+		{"index on object", "$[0]", "does not match"},
+		{"missing bracket", "$.devices[0", "missing ']'"},
+		{"missing closing quote", "$['devices]", "missing closing quote"},
+		{"missing selector", "$.devices.", "missing selector"},
+		{"empty key", "$...name", "empty key"},
+		{"invalid start", "$devices", "invalid JSONPath"},
+		{"slices are not supported", "$.devices[0:1]", "invalid JSONPath selector"},
+		{"filters are not supported", "$.devices[?(@.level > 1)]", "invalid JSONPath selector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := evalJsonPath(tt.expr, doc)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error about %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/veeainc/utils.go/types"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// Kind of change between two node definitions.
+type changeKind int
+
+const (
+	changeAdd     changeKind = iota // element added
+	changeDel                       // element removed
+	changeSet                       // attribute value changed
+	changeReplace                   // element kind or type changed
+)
+
+// A change between two node definitions (Json trees used with 'node add').
+type nodeChange struct {
+	kind  changeKind
+	path  []string    // path relative to the created node
+	value interface{} // new definition (nil for changeDel)
+}
+
+// Tells if a node definition element is a method declaration.
+func isMethodDecl(v interface{}) bool {
+	return types.HasKey(v, "$method")
+}
+
+// Compute the changes needed to go from the old to the new node definition.
+// Changes are sorted by path to be applied in a stable order.
+func diffNodeDefs(old, new vBus.JsonObj, path []string) []nodeChange {
+	var changes []nodeChange
+
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			changes = append(changes, nodeChange{kind: changeDel, path: appendPath(path, k)})
+		}
+	}
+
+	for k, newValue := range new {
+		oldValue, ok := old[k]
+		childPath := appendPath(path, k)
+
+		if !ok {
+			changes = append(changes, nodeChange{kind: changeAdd, path: childPath, value: newValue})
+			continue
+		}
+
+		oldNode, oldIsNode := oldValue.(vBus.JsonObj)
+		newNode, newIsNode := newValue.(vBus.JsonObj)
+		switch {
+		case reflect.DeepEqual(oldValue, newValue):
+			// unchanged
+		case oldIsNode && newIsNode && !isMethodDecl(oldNode) && !isMethodDecl(newNode):
+			changes = append(changes, diffNodeDefs(oldNode, newNode, childPath)...)
+		case !oldIsNode && !newIsNode && oldValue != nil && newValue != nil &&
+			reflect.TypeOf(oldValue) == reflect.TypeOf(newValue):
+			changes = append(changes, nodeChange{kind: changeSet, path: childPath, value: newValue})
+		default:
+			changes = append(changes, nodeChange{kind: changeReplace, path: childPath, value: newValue})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return strings.Join(changes[i].path, ".") < strings.Join(changes[j].path, ".")
+	})
+	return changes
+}
+
+// A node created with 'node add' that can be updated from a new definition.
+type reloadableNode struct {
+	conn  *vBus.Client
+	node  *vBus.Node
+	tree  vBus.JsonObj // definition currently published
+	state *nodeState
+	nats  *nats.Conn // raw connection for add and del notifications, opened on first use
+}
+
+// Apply a new definition on the published node.
+// Attribute value changes are published with 'set' notifications. Added and removed elements are
+// published with 'add' and 'del' notifications on their parent node. When a change fails, the
+// changes already applied are kept and the next update starts from them.
+func (r *reloadableNode) update(tree vBus.JsonObj) error {
+	for _, change := range diffNodeDefs(r.tree, tree, nil) {
+		switch change.kind {
+		case changeSet:
+			attr, err := r.node.GetAttribute(change.path...)
+			if err != nil {
+				return errors.Wrap(err, "cannot find attribute "+strings.Join(change.path, "."))
+			}
+			if r.state != nil {
+				if err := r.state.set(change.path, change.value); err != nil {
+					return err
+				}
+			}
+			if err := attr.SetValue(change.value); err != nil {
+				return err
+			}
+			setTreeElement(r.tree, change.path, change.value)
+
+		case changeDel:
+			if err := r.removeElement(change.path); err != nil {
+				return err
+			}
+
+		case changeAdd, changeReplace:
+			if change.kind == changeReplace {
+				if err := r.removeElement(change.path); err != nil {
+					return err
+				}
+			}
+			if err := r.addElement(change.path, change.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Create the definition of an element from its value in a node definition file.
+func (r *reloadableNode) newElementDef(path []string, value interface{}) (vBus.IDefinition, error) {
+	p := strings.Join(path, ".")
+	if value == nil {
+		return nil, errors.New("attribute without value: " + p)
+	}
+	if method, ok := types.GetKey(value, "$method").(vBus.JsonObj); ok {
+		def, err := jsonObjToMethodDef(method)
+		return def, errors.Wrap(err, "method not valid: "+p)
+	}
+	if obj, ok := value.(vBus.JsonObj); ok {
+		rawNode := jsonObjToRawDef(obj, path, r.state)
+		if rawNode == nil {
+			return nil, errors.New("node not valid: " + p)
+		}
+		return vBus.NewNodeDef(rawNode), nil
+	}
+	if r.state != nil {
+		return r.state.newAttributeDef(path, value), nil
+	}
+	return vBus.NewAttributeDef(path[len(path)-1], value), nil
+}
+
+// Get the definition of a nested node of the published node, an empty path gives the published node.
+func (r *reloadableNode) getNodeDef(path []string) (*vBus.NodeDef, error) {
+	def := r.node.Definition()
+	for i, segment := range path {
+		child, ok := def.Structure()[segment].(*vBus.NodeDef)
+		if !ok {
+			return nil, errors.New("cannot find node " + strings.Join(path[:i+1], "."))
+		}
+		def = child
+	}
+	return def, nil
+}
+
+// Publish an element and notify vBus.
+func (r *reloadableNode) addElement(path []string, value interface{}) error {
+	def, err := r.newElementDef(path, value)
+	if err != nil {
+		return err
+	}
+	parent, err := r.getNodeDef(path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+
+	uuid := path[len(path)-1]
+	parent.AddChild(uuid, def)
+	setTreeElement(r.tree, path, value)
+	return r.notify(path, "add", def)
+}
+
+// Remove an element and notify vBus.
+func (r *reloadableNode) removeElement(path []string) error {
+	parent, err := r.getNodeDef(path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+
+	def := parent.RemoveChild(path[len(path)-1])
+	if def == nil {
+		return errors.New("cannot find element " + strings.Join(path, "."))
+	}
+	setTreeElement(r.tree, path, nil)
+	return r.notify(path, "del", def)
+}
+
+// Publish an 'add' or 'del' notification for an element on its parent node, as vbus.go does.
+// vbus.go only gives access to the created node, nested nodes are notified with a raw Nats connection.
+func (r *reloadableNode) notify(path []string, event string, def vBus.IDefinition) error {
+	if r.nats == nil {
+		client, err := getNatsConnection()
+		if err != nil {
+			return err
+		}
+		r.nats = client
+	}
+
+	segments := append([]string{r.conn.GetId(), r.conn.GetHostname(), r.node.GetPath()}, path[:len(path)-1]...)
+	subject := strings.Join(append(segments, event), ".")
+	data, err := json.Marshal(vBus.JsonObj{path[len(path)-1]: def.ToRepr()})
+	if err != nil {
+		return errors.Wrap(err, "cannot encode element")
+	}
+	return errors.Wrap(r.nats.Publish(subject, data), "cannot publish "+subject)
+}
+
+// Close the raw Nats connection, if any.
+func (r *reloadableNode) close() {
+	if r.nats != nil {
+		r.nats.Close()
+	}
+}
+
+// Set an element of a node definition tree, a nil value removes it.
+func setTreeElement(tree vBus.JsonObj, path []string, value interface{}) {
+	for _, segment := range path[:len(path)-1] {
+		tree = tree[segment].(vBus.JsonObj)
+	}
+	if value == nil {
+		delete(tree, path[len(path)-1])
+	} else {
+		tree[path[len(path)-1]] = value
+	}
+}
+
+// Read a node definition file.
+func readNodeFile(filename string, format string) (vBus.JsonObj, error) {
+	tree, err := readInputFile(filename, format)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := tree.(vBus.JsonObj)
+	if !ok {
+		return nil, errors.New("Not a valid Json object")
+	}
+	return obj, nil
+}
+
+// Watch a node definition file and apply its changes until Ctrl+C is pressed.
+func watchNodeFile(filename string, format string, r *reloadableNode) {
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	defer signal.Stop(signalChannel)
+	defer r.close()
+
+	var lastModTime time.Time
+	if info, err := os.Stat(filename); err == nil {
+		lastModTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signalChannel:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(filename)
+			if err != nil || info.ModTime().Equal(lastModTime) {
+				continue // missing while being saved or unchanged
+			}
+			lastModTime = info.ModTime()
+
+			tree, err := readNodeFile(filename, format)
+			if err != nil {
+				logR.WithFields(lf{"file": filename, "error": err.Error()}).Error("cannot reload node, keeping previous definition")
+				continue
+			}
+
+			if err := r.update(tree); err != nil {
+				logR.WithFields(lf{"file": filename, "error": err.Error()}).Error("cannot apply node changes")
+				continue
+			}
+			logR.WithFields(lf{"file": filename}).Info("node reloaded")
+		}
+	}
+}
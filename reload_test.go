@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	vBus "github.com/veeainc/vbus.go"
+)
+
+func TestDiffNodeDefs(t *testing.T) {
+	scan := vBus.JsonObj{"$method": vBus.JsonObj{"exec": "scan.sh"}}
+
+	old := vBus.JsonObj{
+		"name":   "lamp",
+		"level":  10.0,
+		"legacy": true,
+		"mode":   "auto",
+		"config": vBus.JsonObj{"channel": 11.0, "old": "x"},
+		"scan":   scan,
+	}
+	new := vBus.JsonObj{
+		"name":   "lamp",
+		"level":  20.0,
+		"mode":   vBus.JsonObj{"auto": true},
+		"config": vBus.JsonObj{"channel": 11.0, "pan": "0x1a"},
+		"scan":   vBus.JsonObj{"$method": vBus.JsonObj{"exec": "scan.sh --all"}},
+		"reset":  vBus.JsonObj{"$method": vBus.JsonObj{"exec": "reset.sh"}},
+	}
+
+	want := []nodeChange{
+		{kind: changeDel, path: []string{"config", "old"}},
+		{kind: changeAdd, path: []string{"config", "pan"}, value: "0x1a"},
+		{kind: changeDel, path: []string{"legacy"}},
+		{kind: changeSet, path: []string{"level"}, value: 20.0},
+		{kind: changeReplace, path: []string{"mode"}, value: new["mode"]},
+		{kind: changeAdd, path: []string{"reset"}, value: new["reset"]},
+		{kind: changeReplace, path: []string{"scan"}, value: new["scan"]},
+	}
+	if got := diffNodeDefs(old, new, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	if got := diffNodeDefs(old, old, nil); len(got) != 0 {
+		t.Errorf("expected no change, got %+v", got)
+	}
+}
+
+func TestSetTreeElement(t *testing.T) {
+	tree := vBus.JsonObj{"config": vBus.JsonObj{"channel": 11.0}}
+
+	setTreeElement(tree, []string{"config", "pan"}, "0x1a")
+	setTreeElement(tree, []string{"config", "channel"}, nil)
+	setTreeElement(tree, []string{"name"}, "lamp")
+
+	want := vBus.JsonObj{"config": vBus.JsonObj{"pan": "0x1a"}, "name": "lamp"}
+	if !reflect.DeepEqual(tree, want) {
+		t.Errorf("got %v, want %v", tree, want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jeremywohl/flatten"
+	"github.com/pkg/errors"
+	"github.com/tidwall/pretty"
+	"github.com/veeainc/utils.go/system"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats (see also input formats).
+const (
+	formatPretty = "pretty"
+	formatFlat   = "flat"
+	formatTable  = "table"
+	formatRaw    = "raw"
+)
+
+// Output format selected with --output, commands use their own default when empty.
+var outputFormat = ""
+
+// Write a value to an output.
+type renderer func(w io.Writer, value interface{}) error
+
+// Available output renderers, by --output name.
+var renderers = map[string]renderer{
+	formatJson:   renderJson,
+	formatPretty: renderPretty,
+	formatYaml:   renderYaml,
+	formatFlat:   renderFlat,
+	formatTable:  renderTable,
+	formatRaw:    renderRaw,
+}
+
+// Get the names of available renderers.
+func getOutputFormats() []string {
+	var names []string
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check an output format name.
+func checkOutputFormat(format string) error {
+	if _, ok := renderers[format]; !ok {
+		return fmt.Errorf("unknown output format '%s' (expected one of: %s)", format, strings.Join(getOutputFormats(), ", "))
+	}
+	return nil
+}
+
+// Print a value on stdout, with the --output format or `defaultFormat` when not set.
+func renderOutput(value interface{}, defaultFormat string) error {
+	format := outputFormat
+	if format == "" {
+		format = defaultFormat
+	}
+	return renderFormat(value, format)
+}
+
+// Print a value in a given format, whatever --output is.
+func renderFormat(value interface{}, format string) error {
+	if err := checkOutputFormat(format); err != nil {
+		return err
+	}
+	return renderers[format](os.Stdout, value)
+}
+
+// Print a value like renderOutput, errors are only logged (for notification callbacks).
+func printOutput(value interface{}, defaultFormat string) {
+	if err := renderOutput(value, defaultFormat); err != nil {
+		log.Print(err)
+	}
+}
+
+// Compact Json, one value per line.
+func renderJson(w io.Writer, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode output")
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// Indented Json, colored when stdout is a terminal.
+func renderPretty(w io.Writer, value interface{}) error {
+	b, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode output")
+	}
+	if system.IsTty() {
+		b = pretty.Color(b, nil)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// Yaml documents, each value starts with a '---' separator so streams can be parsed.
+func renderYaml(w io.Writer, value interface{}) error {
+	value, err := toJsonValue(value) // use Json field names
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode output")
+	}
+	_, err = fmt.Fprint(w, "---\n"+string(b))
+	return err
+}
+
+// One `path value` line per leaf, sorted by path.
+func renderFlat(w io.Writer, value interface{}) error {
+	flat, err := flattenValue(value)
+	if err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(flat) {
+		line := fmt.Sprintf("%s %v", k, flat[k])
+		if k == "" {
+			line = fmt.Sprint(flat[k]) // a scalar value
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Aligned PATH and VALUE columns, one row per leaf.
+func renderTable(w io.Writer, value interface{}) error {
+	flat, err := flattenValue(value)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tVALUE")
+	for _, k := range sortedKeys(flat) {
+		fmt.Fprintf(tw, "%s\t%s\n", k, formatLeaf(flat[k]))
+	}
+	return tw.Flush()
+}
+
+// Strings without quotes, other values as compact Json.
+func renderRaw(w io.Writer, value interface{}) error {
+	if str, ok := value.(string); ok {
+		_, err := fmt.Fprintln(w, str)
+		return err
+	}
+	return renderJson(w, value)
+}
+
+// Flatten a value to dot style paths, a scalar gives a single entry with an empty path.
+func flattenValue(value interface{}) (map[string]interface{}, error) {
+	value, err := toJsonValue(value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return flatten.Flatten(v, "", flatten.DotStyle)
+	case []interface{}:
+		indexed := make(map[string]interface{}, len(v))
+		for i, item := range v {
+			indexed[strconv.Itoa(i)] = item
+		}
+		return flatten.Flatten(indexed, "", flatten.DotStyle)
+	default:
+		return map[string]interface{}{"": v}, nil
+	}
+}
+
+// Format a flattened leaf for a table cell.
+func formatLeaf(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	return goToJson(value)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// Status of an attribute in a restore report.
+const (
+	restoreWritten   = "written"
+	restorePlanned   = "planned" // dry-run
+	restoreUnchanged = "unchanged"
+	restoreSkipped   = "skipped"
+	restoreFailed    = "failed"
+)
+
+// The result of restoring one attribute.
+type restoreResult struct {
+	Path   string      `json:"path"`
+	Status string      `json:"status"`
+	Value  interface{} `json:"value,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// Glob filters on attribute paths relative to the snapshot path.
+type restoreFilter struct {
+	only    []string
+	exclude []string
+}
+
+// Check filter patterns syntax.
+func (f restoreFilter) validate() error {
+	for _, pattern := range append(append([]string{}, f.only...), f.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrap(err, "invalid pattern: "+pattern)
+		}
+	}
+	return nil
+}
+
+// Tells if a path is selected by the filter.
+func (f restoreFilter) match(p string) bool {
+	for _, pattern := range f.exclude {
+		if matched, _ := path.Match(pattern, p); matched {
+			return false
+		}
+	}
+	if len(f.only) == 0 {
+		return true
+	}
+	for _, pattern := range f.only {
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the attribute values of a snapshot on a target node (the host node for a domain.app path).
+// Attributes missing on the target, read-only or with an invalid value are skipped with a warning.
+// Current values are read with `readTimeout` to skip unchanged attributes.
+func restoreSnapshot(s *snapshot, target *vBus.NodeProxy, filter restoreFilter, dryRun bool, readTimeout time.Duration) []restoreResult {
+	attrs := make(map[string]*vBus.AttributeProxy)
+	collectAttributes(target, nil, attrs)
+
+	var paths []string
+	for p, elem := range s.Elements {
+		if elem.Kind == "attribute" && filter.match(p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var results []restoreResult
+	for _, p := range paths {
+		result := restoreAttribute(p, s.Elements[p], attrs[p], dryRun, readTimeout)
+		switch result.Status {
+		case restoreSkipped, restoreFailed:
+			logR.WithFields(lf{"path": p, "reason": result.Reason}).Warn("attribute " + result.Status)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Restore one attribute value, `attr` is nil when missing on the target.
+func restoreAttribute(p string, elem *snapshotElement, attr *vBus.AttributeProxy, dryRun bool, readTimeout time.Duration) restoreResult {
+	result := restoreResult{Path: p, Value: elem.Value}
+
+	switch {
+	case elem.Error != "":
+		result.Status, result.Reason = restoreSkipped, "value not read in snapshot: "+elem.Error
+		return result
+	case attr == nil:
+		result.Status, result.Reason = restoreSkipped, "missing on target"
+		return result
+	case isReadOnly(attr.Schema()):
+		result.Status, result.Reason = restoreSkipped, "read-only"
+		return result
+	}
+
+	current, err := attr.ReadValueWithTimeout(readTimeout)
+	switch {
+	case err != nil:
+		result.Status, result.Reason = restoreSkipped, "cannot read current value: "+oneLine(err.Error())
+	case reflect.DeepEqual(current, elem.Value):
+		result.Status = restoreUnchanged
+	default:
+		if err := validateValue(attr.Schema(), elem.Value); err != nil {
+			result.Status, result.Reason = restoreSkipped, oneLine(err.Error())
+		} else if dryRun {
+			result.Status = restorePlanned
+		} else if err := attr.SetValue(elem.Value); err != nil {
+			result.Status, result.Reason = restoreFailed, oneLine(err.Error())
+		} else {
+			result.Status = restoreWritten
+		}
+	}
+	return result
+}
+
+// Get the attributes of a node, by dot style path relative to the node.
+func collectAttributes(node *vBus.NodeProxy, p []string, attrs map[string]*vBus.AttributeProxy) {
+	for name, elem := range node.Elements() {
+		elemPath := appendPath(p, name)
+		if elem.IsAttribute() {
+			attrs[strings.Join(elemPath, ".")] = elem.AsAttribute()
+		} else if elem.IsNode() {
+			collectAttributes(elem.AsNode(), elemPath, attrs)
+		}
+	}
+}
+
+// Tells if a Json-Schema declares a read-only value.
+func isReadOnly(schema vBus.JsonObj) bool {
+	readOnly, _ := schema["readOnly"].(bool)
+	return readOnly
+}
+
+// Join the lines of a multi-line message.
+func oneLine(msg string) string {
+	return strings.Join(strings.Fields(strings.Replace(msg, "\n  - ", "; ", -1)), " ")
+}
+
+// Print a restore report as a table followed by a summary.
+func printRestoreReport(results []restoreResult) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tPATH\tVALUE\tREASON")
+	counts := make(map[string]int)
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Status, r.Path, formatCell(r.Value), r.Reason)
+		counts[r.Status]++
+	}
+	_ = tw.Flush()
+
+	var summary []string
+	for _, status := range []string{restoreWritten, restorePlanned, restoreUnchanged, restoreSkipped, restoreFailed} {
+		if counts[status] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "nothing to restore")
+	}
+	fmt.Println()
+	fmt.Println(strings.Join(summary, ", "))
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestRestoreFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter restoreFilter
+		path   string
+		want   bool
+	}{
+		{"no filter", restoreFilter{}, "controller.channel", true},
+		{"only matches", restoreFilter{only: []string{"controller.*"}}, "controller.channel", true},
+		{"only does not match", restoreFilter{only: []string{"controller.*"}}, "devices.lamp", false},
+		{"glob matches nested paths", restoreFilter{only: []string{"controller.*"}}, "controller.network.channel", true},
+		{"one of only matches", restoreFilter{only: []string{"devices.*", "controller.*"}}, "controller.channel", true},
+		{"excluded", restoreFilter{exclude: []string{"*.password"}}, "wifi.password", false},
+		{"not excluded", restoreFilter{exclude: []string{"*.password"}}, "wifi.ssid", true},
+		{"exclude wins over only", restoreFilter{only: []string{"wifi.*"}, exclude: []string{"wifi.password"}}, "wifi.password", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.path); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreFilterValidate(t *testing.T) {
+	if err := (restoreFilter{only: []string{"controller.*"}}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (restoreFilter{exclude: []string{"controller.["}}).validate(); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
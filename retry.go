@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Retry policy used by --wait and --loop: exponential backoff with jitter.
+type retryPolicy struct {
+	initial    time.Duration // first delay
+	max        time.Duration // delay cap
+	maxWait    time.Duration // give up after this duration (0 means no deadline)
+	maxRetries int           // give up after this number of retries (0 means no limit)
+}
+
+// Retry policy set with global flags.
+var retry = retryPolicy{initial: time.Second, max: 30 * time.Second}
+
+var retryRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Get the delay before a retry (starting at 1): the backoff is doubled on each attempt up to
+// the cap, then a random jitter removes up to half of it so clients do not retry all together.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	backoff := p.initial
+	for i := 1; i < attempt && backoff < p.max; i++ {
+		backoff *= 2
+	}
+	if backoff > p.max {
+		backoff = p.max
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	half := int64(backoff / 2)
+	return time.Duration(half + retryRand.Int63n(half+1))
+}
+
+// Run `fn` until it succeeds or the policy gives up.
+func (p retryPolicy) run(what string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	if err == nil {
+		return nil
+	}
+	return p.retryAfter(what, start, err, fn)
+}
+
+// Retry `fn` after a first failure at `start`, until it succeeds or the policy gives up.
+// Each attempt is logged with its error.
+func (p retryPolicy) retryAfter(what string, start time.Time, err error, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		if p.maxRetries > 0 && attempt > p.maxRetries {
+			return errors.Wrapf(err, "%s: giving up after %d retries", what, p.maxRetries)
+		}
+
+		delay := p.delay(attempt)
+		if p.maxWait > 0 && time.Since(start)+delay > p.maxWait {
+			return errors.Wrapf(err, "%s: giving up after %s", what, p.maxWait)
+		}
+
+		logR.WithFields(lf{
+			"attempt":  attempt,
+			"error":    err.Error(),
+			"retry_in": delay.Truncate(time.Millisecond).String(),
+		}).Warn(what + " failed, retrying")
+		time.Sleep(delay)
+
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := retryPolicy{initial: time.Second, max: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		backoff time.Duration // the delay is between half of it and itself
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.delay(tt.attempt); d < tt.backoff/2 || d > tt.backoff {
+				t.Fatalf("delay(%d) = %s, want between %s and %s", tt.attempt, d, tt.backoff/2, tt.backoff)
+			}
+		}
+	}
+
+	if d := (retryPolicy{}).delay(1); d != 0 {
+		t.Errorf("delay without backoff = %s, want 0", d)
+	}
+}
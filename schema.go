@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/veeainc/utils.go/types"
+	vBus "github.com/veeainc/vbus.go"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Validate a value against a Json-Schema before sending it on vBus.
+// The returned error lists every failing field with a readable message.
+func validateValue(schema vBus.JsonObj, value interface{}) error {
+	return validateWithFieldNames(schema, value, func(field string) string {
+		if field == gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+			return "value"
+		}
+		return field
+	})
+}
+
+// Validate method arguments against the method params schema.
+// Failing fields are reported with the argument title when available.
+func validateMethodArgs(paramsSchema vBus.JsonObj, args []interface{}) error {
+	if args == nil {
+		args = []interface{}{} // no argument is an empty array, not null
+	}
+	items := getParamsItems(paramsSchema)
+	return validateWithFieldNames(paramsSchema, args, func(field string) string {
+		if field == gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+			return "args"
+		}
+		parts := strings.SplitN(field, ".", 2)
+		if i, err := strconv.Atoi(parts[0]); err == nil && i < len(items) {
+			parts[0] = getParamTitle(items[i], i)
+		}
+		return strings.Join(parts, ".")
+	})
+}
+
+func validateWithFieldNames(schema vBus.JsonObj, value interface{}, fieldName func(string) string) error {
+	if schema == nil {
+		return nil // nothing to validate against
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return errors.Wrap(err, "cannot validate value (invalid schema?)")
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	var messages []string
+	for _, e := range result.Errors() {
+		messages = append(messages, fieldName(e.Field())+": "+e.Description())
+	}
+	return validationError("invalid value:\n  - " + strings.Join(messages, "\n  - "))
+}
+
+// Get the positional items of a method params schema.
+func getParamsItems(paramsSchema vBus.JsonObj) JsonArray {
+	if items, ok := types.GetKey(paramsSchema, "items").(JsonArray); ok {
+		return items
+	}
+	return nil
+}
+
+// Get a param title, or its position when it has no title.
+func getParamTitle(item interface{}, i int) string {
+	if title, ok := types.GetKey(item, "title").(string); ok && title != "" {
+		return title
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// Convert named arguments (title=value) to positional method arguments.
+// Each value is converted to the type declared by its schema item. Params after the schema minItems
+// can be omitted, args stop at the last given one.
+func namedArgsToPositional(paramsSchema vBus.JsonObj, named []string) ([]interface{}, error) {
+	items := getParamsItems(paramsSchema)
+	if items == nil {
+		return nil, errors.New("this method does not declare its params, use a Json array instead")
+	}
+
+	var titles []string
+	for i, item := range items {
+		titles = append(titles, getParamTitle(item, i))
+	}
+
+	args := make([]interface{}, len(items))
+	found := make([]bool, len(items))
+	for _, arg := range named {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid argument (expected title=value): " + arg)
+		}
+
+		i := indexOf(titles, parts[0])
+		if i < 0 {
+			return nil, fmt.Errorf("unknown argument '%s' (expected one of: %s)", parts[0], strings.Join(titles, ", "))
+		}
+		if found[i] {
+			return nil, fmt.Errorf("argument '%s' given twice", parts[0])
+		}
+
+		value, err := coerceToSchema(parts[1], items[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid argument '%s'", parts[0])
+		}
+		args[i] = value
+		found[i] = true
+	}
+
+	// trailing params after minItems are optional, but positional args cannot have gaps
+	required := getMinItems(paramsSchema)
+	for i := range items {
+		if found[i] && i+1 > required {
+			required = i + 1
+		}
+	}
+	if required > len(items) {
+		required = len(items)
+	}
+	for i := 0; i < required; i++ {
+		if !found[i] {
+			return nil, fmt.Errorf("missing argument '%s'", titles[i])
+		}
+	}
+	return args[:required], nil
+}
+
+// Get the minItems of a params schema, 0 when not set.
+func getMinItems(paramsSchema vBus.JsonObj) int {
+	switch n := types.GetKey(paramsSchema, "minItems").(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// Convert a command line string to the type declared by a Json-Schema.
+// Complex or unknown types are parsed as Json.
+func coerceToSchema(raw string, schema interface{}) (interface{}, error) {
+	switch types.GetKey(schema, "type") {
+	case "string":
+		return raw, nil
+	case "integer":
+		return strconv.ParseInt(raw, 10, 64)
+	case "number":
+		return strconv.ParseFloat(raw, 64)
+	case "boolean":
+		return strconv.ParseBool(raw)
+	case "null":
+		if raw != "null" {
+			return nil, errors.New("expected null")
+		}
+		return nil, nil
+	default:
+		return jsonToGoErr(raw)
+	}
+}
+
+func indexOf(list []string, str string) int {
+	for i, s := range list {
+		if s == str {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	vBus "github.com/veeainc/vbus.go"
+)
+
+func TestValidateMethodArgs(t *testing.T) {
+	noParams := vBus.JsonObj{"type": "array", "items": []interface{}{}}
+	oneParam := vBus.JsonObj{
+		"type":     "array",
+		"items":    []interface{}{map[string]interface{}{"type": "integer", "title": "duration"}},
+		"minItems": 1,
+	}
+
+	tests := []struct {
+		name    string
+		schema  vBus.JsonObj
+		args    []interface{}
+		wantErr string
+	}{
+		{"no args (nil)", noParams, nil, ""},
+		{"no args (empty)", noParams, []interface{}{}, ""},
+		{"no schema", nil, nil, ""},
+		{"valid arg", oneParam, []interface{}{120}, ""},
+		{"missing arg", oneParam, nil, "args"},
+		{"invalid arg", oneParam, []interface{}{"soon"}, "duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMethodArgs(tt.schema, tt.args)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error about %q, got %v", tt.wantErr, err)
+			}
+			if getErrorClass(err) != classValidation {
+				t.Errorf("expected a validation error, got %s", getErrorClass(err))
+			}
+		})
+	}
+}
+
+func TestCoerceToSchema(t *testing.T) {
+	tests := []struct {
+		raw     string
+		schema  interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"hello", map[string]interface{}{"type": "string"}, "hello", false},
+		{"42", map[string]interface{}{"type": "integer"}, int64(42), false},
+		{"4.2", map[string]interface{}{"type": "integer"}, nil, true},
+		{"4.5", map[string]interface{}{"type": "number"}, 4.5, false},
+		{"true", map[string]interface{}{"type": "boolean"}, true, false},
+		{"yes", map[string]interface{}{"type": "boolean"}, nil, true},
+		{"null", map[string]interface{}{"type": "null"}, nil, false},
+		{"0", map[string]interface{}{"type": "null"}, nil, true},
+		{`{"a":1}`, map[string]interface{}{"type": "object"}, map[string]interface{}{"a": 1.0}, false},
+		{"[1,2]", nil, []interface{}{1.0, 2.0}, false},
+	}
+	for _, tt := range tests {
+		got, err := coerceToSchema(tt.raw, tt.schema)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("coerceToSchema(%q, %v): expected an error, got %v", tt.raw, tt.schema, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("coerceToSchema(%q, %v): unexpected error: %v", tt.raw, tt.schema, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("coerceToSchema(%q, %v) = %#v, want %#v", tt.raw, tt.schema, got, tt.want)
+		}
+	}
+}
+
+func TestNamedArgsToPositional(t *testing.T) {
+	schema := vBus.JsonObj{
+		"type": "array",
+		"items": []interface{}{
+			map[string]interface{}{"type": "string", "title": "name"},
+			map[string]interface{}{"type": "integer", "title": "duration"},
+			map[string]interface{}{"type": "boolean", "title": "force"},
+		},
+		"minItems": 1.0,
+	}
+
+	tests := []struct {
+		name    string
+		named   []string
+		want    []interface{}
+		wantErr string
+	}{
+		{"all args", []string{"force=true", "name=lamp", "duration=10"}, []interface{}{"lamp", int64(10), true}, ""},
+		{"optional trailing args", []string{"name=lamp"}, []interface{}{"lamp"}, ""},
+		{"cut after the last given arg", []string{"name=lamp", "duration=10"}, []interface{}{"lamp", int64(10)}, ""},
+		{"missing required arg", []string{"duration=10"}, nil, "missing argument 'name'"},
+		{"gap before a given arg", []string{"name=lamp", "force=true"}, nil, "missing argument 'duration'"},
+		{"unknown arg", []string{"name=lamp", "speed=2"}, nil, "unknown argument 'speed'"},
+		{"invalid value", []string{"name=lamp", "duration=soon"}, nil, "invalid argument 'duration'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := namedArgsToPositional(schema, tt.named)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error about %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
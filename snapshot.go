@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+const (
+	snapshotFormat  = "vbus-cmd-snapshot"
+	snapshotVersion = 1
+)
+
+// A snapshot of a vBus tree, with live attribute values.
+type snapshot struct {
+	Format   string                      `json:"format"`
+	Version  int                         `json:"version"`
+	Time     string                      `json:"time"`
+	Path     string                      `json:"path"`           // discovered path
+	Host     string                      `json:"host,omitempty"` // hub hostname, when Path is a domain.app path
+	Elements map[string]*snapshotElement `json:"elements"`       // by dot style path relative to Path and Host
+}
+
+// A node, attribute or method in a snapshot.
+type snapshotElement struct {
+	Kind    string       `json:"kind"`
+	Schema  vBus.JsonObj `json:"schema,omitempty"`  // attribute schema
+	Value   interface{}  `json:"value,omitempty"`   // attribute value
+	Error   string       `json:"error,omitempty"`   // attribute read error (value is the discovered one)
+	Params  vBus.JsonObj `json:"params,omitempty"`  // method params schema
+	Returns vBus.JsonObj `json:"returns,omitempty"` // method returns schema
+}
+
+// An attribute value to read.
+type snapshotRead struct {
+	attr    *vBus.AttributeProxy
+	element *snapshotElement
+}
+
+// Discover the tree on a path and get its host node.
+// Discover is answered at domain.app level with a {hostname: tree} object: the host node is selected
+// with `host` (or is the only one), so elements of different hubs are compared on the same paths.
+// On a deeper path, the discovered node is returned as is.
+func discoverHostNode(conn *vBus.Client, p, host string, timeout time.Duration) (*vBus.NodeProxy, string, error) {
+	elem, err := conn.Discover(sanitizePath(p, conn), timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	if !elem.IsNode() || len(elem.AsNode().Tree()) == 0 {
+		return nil, "", notFoundError("nothing found on " + p)
+	}
+	if len(strings.Split(p, ".")) != 2 {
+		return elem.AsNode(), "", nil
+	}
+
+	hosts := elem.AsNode().Elements()
+	var names []string
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if host == "" {
+		if len(names) > 1 {
+			return nil, "", validationError(fmt.Sprintf("several hosts answered on %s (%s), select one with --host", p, strings.Join(names, ", ")))
+		}
+		host = names[0]
+	} else if host == "local" {
+		host = conn.GetHostname()
+	}
+
+	node, ok := hosts[host]
+	if !ok || !node.IsNode() {
+		return nil, "", notFoundError(fmt.Sprintf("host %s not found on %s (available: %s)", host, p, strings.Join(names, ", ")))
+	}
+	return node.AsNode(), host, nil
+}
+
+// Take a snapshot of a node discovered on `path`, `host` is its hostname for a domain.app path.
+// Attribute values are read by `workers` concurrent readers.
+func takeSnapshot(root *vBus.NodeProxy, path, host string, readTimeout time.Duration, workers int) *snapshot {
+	s := &snapshot{
+		Format:   snapshotFormat,
+		Version:  snapshotVersion,
+		Time:     time.Now().Format(time.RFC3339),
+		Path:     path,
+		Host:     host,
+		Elements: make(map[string]*snapshotElement),
+	}
+
+	var reads []snapshotRead
+	collectSnapshotElements(root, nil, s.Elements, &reads)
+
+	jobs := make(chan snapshotRead)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for read := range jobs {
+				value, err := read.attr.ReadValueWithTimeout(readTimeout)
+				if err != nil {
+					logR.WithFields(lf{"path": read.attr.GetPath(), "error": err.Error()}).Warn("cannot read attribute, keeping discovered value")
+					read.element.Error = err.Error()
+					continue
+				}
+				read.element.Value = value
+			}
+		}()
+	}
+	for _, read := range reads {
+		jobs <- read
+	}
+	close(jobs)
+	wg.Wait()
+
+	return s
+}
+
+// Add the elements of a node to a snapshot, attributes to read are appended to `reads`.
+func collectSnapshotElements(node *vBus.NodeProxy, path []string, elements map[string]*snapshotElement, reads *[]snapshotRead) {
+	for name, elem := range node.Elements() {
+		elemPath := appendPath(path, name)
+		key := strings.Join(elemPath, ".")
+
+		switch {
+		case elem.IsAttribute():
+			attr := elem.AsAttribute()
+			element := &snapshotElement{Kind: "attribute", Schema: attr.Schema(), Value: attr.Value()}
+			elements[key] = element
+			*reads = append(*reads, snapshotRead{attr: attr, element: element})
+		case elem.IsMethod():
+			method := elem.AsMethod()
+			elements[key] = &snapshotElement{Kind: "method", Params: method.ParamsSchema(), Returns: method.ReturnsSchema()}
+		default:
+			elements[key] = &snapshotElement{Kind: "node"}
+			collectSnapshotElements(elem.AsNode(), elemPath, elements, reads)
+		}
+	}
+}
+
+// Write a snapshot to a Json file.
+func writeSnapshotFile(filename string, s *snapshot) error {
+	buf, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode snapshot")
+	}
+	return errors.Wrap(ioutil.WriteFile(filename, append(buf, '\n'), 0644), "cannot write snapshot")
+}
+
+// Read a snapshot file written by 'snapshot'.
+func readSnapshotFile(filename string) (*snapshot, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read snapshot")
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, errors.Wrap(err, "cannot parse snapshot")
+	}
+	if s.Format != snapshotFormat {
+		return nil, errors.New("not a vbus-cmd snapshot: " + filename)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d (expected %d)", s.Version, snapshotVersion)
+	}
+	if s.Elements == nil {
+		s.Elements = make(map[string]*snapshotElement)
+	}
+	return &s, nil
+}
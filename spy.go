@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// Spy options, retrieved from command line flags.
+type spyOptions struct {
+	subjects []string      // subject patterns to keep (all when empty)
+	excludes []string      // subject patterns to drop
+	json     bool          // print Json lines instead of log lines
+	max      int           // stop after this number of messages (0 means no limit)
+	duration time.Duration // stop after this duration (0 means no limit)
+	record   string        // capture file path (no capture when empty)
+
+	correlate   bool          // pair requests with their replies
+	replyWindow time.Duration // delay after which a request without reply is flagged
+
+	stats         bool          // display traffic statistics instead of messages
+	statsDepth    int           // number of subject tokens used to aggregate statistics
+	statsTop      int           // number of prefixes displayed
+	statsInterval time.Duration // statistics refresh interval
+}
+
+// Minimum interval between checks of requests without reply.
+const minExpireTick = 10 * time.Millisecond
+
+// A spied message, printed as a Json line.
+type spyMessage struct {
+	Time    string      `json:"time"`
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+func printMsg(m *nats.Msg) {
+	logR.WithFields(lf{
+		"subject": m.Subject,
+		"data":    string(m.Data),
+		"reply":   m.Reply,
+	}).Info("vBus Message")
+}
+
+func printJsonMsg(m *nats.Msg) {
+	printOutput(spyMessage{
+		Time:    time.Now().Format(time.RFC3339Nano),
+		Subject: m.Subject,
+		Reply:   m.Reply,
+		Data:    decodeMsgData(m.Data),
+	}, formatJson)
+}
+
+// Decode a Nats payload as Json when possible, otherwise keep it as a string.
+func decodeMsgData(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return string(data)
+	}
+	return decoded
+}
+
+// Tells if a Nats subject matches a subscription pattern.
+// Patterns use Nats wildcards: '*' matches one token and '>' matches the remaining tokens.
+func subjectMatch(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != "*" && token != subjectTokens[i] {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// Tells if a subject must be displayed according to include and exclude patterns.
+func subjectFiltered(subject string, subjects, excludes []string) bool {
+	for _, pattern := range excludes {
+		if subjectMatch(pattern, subject) {
+			return false
+		}
+	}
+
+	if len(subjects) == 0 {
+		return true
+	}
+	for _, pattern := range subjects {
+		if subjectMatch(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open a direct Nats connection with the credentials stored in the vBus config file.
+func getNatsConnection() (*nats.Conn, error) {
+	config, err := loadClientConfig(domain, appName)
+	if err != nil {
+		return nil, err
+	}
+	url, err := config.getServerUrl()
+	if err != nil {
+		return nil, connectionError(err)
+	}
+
+	client, err := nats.Connect(url, nats.UserInfo(config.Client.User, config.Key.Private), nats.Name(config.Client.User))
+	if err != nil {
+		return nil, connectionError(errors.Wrap(err, "cannot connect to "+url))
+	}
+	return client, nil
+}
+
+// Check subject patterns before connecting.
+func validateSubjectPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if badSubject(pattern) {
+			return validationError("invalid subject pattern: " + pattern)
+		}
+	}
+	return nil
+}
+
+// Print every message received on the Nats connection until Ctrl+C is pressed
+// or a limit is reached. Subject patterns are checked with validateSubjectPatterns.
+func runSpy(client *nats.Conn, opts spyOptions) error {
+	var capture *captureWriter
+	if opts.record != "" {
+		w, err := newCaptureWriter(opts.record)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		capture = w
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+	var mutex sync.Mutex
+	received := 0
+
+	stop := func() { once.Do(func() { close(done) }) }
+
+	// count printed messages, must be called with the mutex locked
+	printed := func(n int) {
+		received += n
+		if opts.max > 0 && received >= opts.max {
+			stop()
+		}
+	}
+
+	var corr *correlator
+	if opts.correlate {
+		corr = newCorrelator(opts.replyWindow, opts.json)
+
+		tick := opts.replyWindow / 4
+		if tick < minExpireTick {
+			tick = minExpireTick
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					mutex.Lock()
+					printed(corr.expire())
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	var stats *trafficStats
+	if opts.stats {
+		stats = newTrafficStats(opts.statsDepth, opts.statsTop)
+
+		ticker := time.NewTicker(opts.statsInterval)
+		defer ticker.Stop()
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					mutex.Lock()
+					stats.render()
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	sub, err := client.Subscribe(">", func(m *nats.Msg) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if opts.max > 0 && received >= opts.max {
+			return // already stopping
+		}
+
+		displayed := subjectFiltered(m.Subject, opts.subjects, opts.excludes)
+		if stats != nil {
+			if displayed {
+				stats.add(m)
+				printed(1)
+			}
+		} else if corr != nil {
+			if corr.handle(m, displayed) {
+				printed(1)
+			}
+		} else if displayed {
+			if opts.json {
+				printJsonMsg(m)
+			} else {
+				printMsg(m)
+			}
+			printed(1)
+		}
+
+		if capture != nil && displayed {
+			if err := capture.Write(m); err != nil {
+				logR.WithFields(lf{
+					"error": err.Error(),
+				}).Error("cannot record message")
+			}
+		}
+	})
+	if err != nil {
+		logR.WithFields(lf{
+			"error": err.Error(),
+		}).Error("cannot subscribe spi")
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	if opts.duration > 0 {
+		timer := time.AfterFunc(opts.duration, stop)
+		defer timer.Stop()
+	}
+
+	log.Println("spi started (exit with Ctrl+C)")
+	waitForCtrlCOrDone(done)
+
+	if stats != nil {
+		mutex.Lock()
+		stats.render() // last interval
+		mutex.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestSubjectMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		want    bool
+	}{
+		{"system.zigbee.hub1", "system.zigbee.hub1", true},
+		{"system.zigbee.hub1", "system.zigbee.hub2", false},
+		{"system.zigbee", "system.zigbee.hub1", false},
+		{"system.zigbee.hub1", "system.zigbee", false},
+		{"system.*.hub1", "system.zigbee.hub1", true},
+		{"system.*", "system.zigbee.hub1", false},
+		{"*", "system", true},
+		{"system.>", "system.zigbee.hub1", true},
+		{"system.>", "system.zigbee", true},
+		{"system.>", "system", false},
+		{">", "system.zigbee", true},
+		{"system.*.>", "system.zigbee", false},
+		{"system.*.>", "system.zigbee.hub1.controller", true},
+	}
+	for _, tt := range tests {
+		if got := subjectMatch(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("subjectMatch(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSubjectPatterns(t *testing.T) {
+	if err := validateSubjectPatterns([]string{"system.>", "*.zigbee.*"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := validateSubjectPatterns([]string{"system.>", "system..zigbee"})
+	if err == nil || getErrorClass(err) != classValidation {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+}
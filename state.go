@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// Attribute values written remotely on nodes created with 'node add'.
+// Values are persisted in a Json file, keyed by their dot style path relative to the node.
+type nodeState struct {
+	filename string
+	mutex    sync.Mutex
+	values   map[string]interface{}
+}
+
+// Load a state file, a missing file gives an empty state.
+func loadNodeState(filename string) (*nodeState, error) {
+	state := &nodeState{
+		filename: filename,
+		values:   make(map[string]interface{}),
+	}
+
+	buf, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "cannot read state file")
+	}
+
+	if err := json.Unmarshal(buf, &state.values); err != nil {
+		return nil, errors.Wrap(err, "cannot parse state file")
+	}
+	return state, nil
+}
+
+// Get a stored value.
+func (s *nodeState) get(path []string) (interface{}, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, ok := s.values[strings.Join(path, ".")]
+	return value, ok
+}
+
+// Store a value and save the state file.
+func (s *nodeState) set(path []string, value interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[strings.Join(path, ".")] = value
+	return s.save()
+}
+
+// Write the state file atomically (write a temporary file then rename it).
+func (s *nodeState) save() error {
+	buf, err := json.MarshalIndent(s.values, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "cannot encode state")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "cannot create state file")
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "cannot write state file")
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "cannot write state file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "cannot write state file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), s.filename), "cannot replace state file")
+}
+
+// Create an attribute definition whose value is read from and written to the state.
+// Remote writes are persisted, `value` is used until the attribute is written.
+func (s *nodeState) newAttributeDef(path []string, value interface{}) *vBus.AttributeDef {
+	if stored, ok := s.get(path); ok {
+		value = stored // also used to infer the schema
+	}
+	return vBus.NewAttributeDef(path[len(path)-1], value, vBus.OnSet(s.onSet(path)), vBus.OnGet(s.onGet(path, value)))
+}
+
+// Get an attribute set callback that persists the received value.
+func (s *nodeState) onSet(path []string) vBus.SetCallback {
+	return func(data interface{}, segment []string) {
+		if err := s.set(path, data); err != nil {
+			logR.WithFields(lf{
+				"path":  strings.Join(path, "."),
+				"error": err.Error(),
+			}).Error("cannot persist attribute value")
+		}
+	}
+}
+
+// Get an attribute get callback that returns the stored value, or `value` if none.
+func (s *nodeState) onGet(path []string, value interface{}) vBus.GetCallback {
+	return func(data interface{}, segment []string) interface{} {
+		if stored, ok := s.get(path); ok {
+			return stored
+		}
+		return value
+	}
+}
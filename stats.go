@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/veeainc/utils.go/system"
+)
+
+// Traffic counters for a subject prefix.
+type subjectStats struct {
+	prefix        string
+	messages      int64 // messages received during the current interval
+	bytes         int64 // bytes received during the current interval
+	totalMessages int64
+	totalBytes    int64
+	largest       int // largest payload size since start
+}
+
+// Aggregate messages by subject prefix.
+// It is not safe for concurrent use.
+type trafficStats struct {
+	depth    int // number of subject tokens used as prefix
+	top      int // number of rows displayed
+	start    time.Time
+	interval time.Time // current interval start
+	prefixes map[string]*subjectStats
+}
+
+func newTrafficStats(depth, top int) *trafficStats {
+	now := time.Now()
+	return &trafficStats{
+		depth:    depth,
+		top:      top,
+		start:    now,
+		interval: now,
+		prefixes: make(map[string]*subjectStats),
+	}
+}
+
+// Get the aggregation prefix of a subject.
+func (s *trafficStats) prefix(subject string) string {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) > s.depth {
+		tokens = append(tokens[:s.depth], ">")
+	}
+	return strings.Join(tokens, ".")
+}
+
+// Count a message.
+func (s *trafficStats) add(m *nats.Msg) {
+	prefix := s.prefix(m.Subject)
+	stats, ok := s.prefixes[prefix]
+	if !ok {
+		stats = &subjectStats{prefix: prefix}
+		s.prefixes[prefix] = stats
+	}
+
+	size := len(m.Data)
+	stats.messages++
+	stats.bytes += int64(size)
+	stats.totalMessages++
+	stats.totalBytes += int64(size)
+	if size > stats.largest {
+		stats.largest = size
+	}
+}
+
+// Print the top prefixes sorted by byte rate, then reset interval counters.
+func (s *trafficStats) render() {
+	now := time.Now()
+	elapsed := now.Sub(s.interval).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	var rows []*subjectStats
+	for _, stats := range s.prefixes {
+		rows = append(rows, stats)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].bytes != rows[j].bytes {
+			return rows[i].bytes > rows[j].bytes
+		}
+		if rows[i].messages != rows[j].messages {
+			return rows[i].messages > rows[j].messages
+		}
+		return rows[i].prefix < rows[j].prefix
+	})
+	if s.top > 0 && len(rows) > s.top {
+		rows = rows[:s.top]
+	}
+
+	if system.IsTty() {
+		fmt.Print("\033[H\033[2J") // clear screen
+	}
+	fmt.Printf("vBus traffic - %s - running for %s\n\n", now.Format("15:04:05"), now.Sub(s.start).Truncate(time.Second))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "MSG/S\tBYTES/S\tLARGEST\tTOTAL MSG\tTOTAL BYTES\t\tPREFIX")
+	for _, stats := range rows {
+		fmt.Fprintf(w, "%.1f\t%s\t%s\t%d\t%s\t\t%s\n",
+			float64(stats.messages)/elapsed,
+			formatBytes(float64(stats.bytes)/elapsed),
+			formatBytes(float64(stats.largest)),
+			stats.totalMessages,
+			formatBytes(float64(stats.totalBytes)),
+			stats.prefix)
+	}
+	_ = w.Flush()
+	fmt.Println()
+
+	// reset interval counters
+	for _, stats := range s.prefixes {
+		stats.messages = 0
+		stats.bytes = 0
+	}
+	s.interval = now
+}
+
+// Format a byte count with a binary unit.
+func formatBytes(b float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/veeainc/utils.go/system"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// Columns of the element table.
+var elementColumns = []string{"PATH", "KIND", "TYPE", "VALUE", "DESCRIPTION"}
+
+// Sort keys accepted by the element table, mapped to their column.
+var elementSortKeys = map[string]int{"path": 0, "kind": 1, "type": 2, "value": 3}
+
+// Minimum width of a column truncated to fit the terminal.
+const minColumnWidth = 8
+
+// A row of the element table.
+type elementRow [5]string
+
+// Compare two cells for sorting: numbers are compared numerically and sorted before other cells.
+func lessCell(a, b string) bool {
+	numA, errA := strconv.ParseFloat(a, 64)
+	numB, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return numA < numB
+	case errA == nil || errB == nil:
+		return errA == nil
+	}
+	return a < b
+}
+
+// List the elements of a raw vBus tree, with their path relative to the tree root.
+// Nodes deeper than `depth` levels (0 means no limit) are listed with their children count only.
+func getElementRows(tree vBus.JsonAny, path []string, level int, depth int) []elementRow {
+	obj, ok := tree.(vBus.JsonObj)
+	if !ok {
+		return nil
+	}
+
+	var rows []elementRow
+	for _, name := range sortedKeys(obj) {
+		elemPath := appendPath(path, name)
+		elem := obj[name]
+
+		switch {
+		case vBus.IsAttribute(elem):
+			attr, _ := elem.(vBus.JsonObj)
+			schema := getKeyObj(attr, "schema")
+			rows = append(rows, elementRow{strings.Join(elemPath, "."), "attribute", getSchemaType(schema),
+				formatCell(attr["value"]), getSchemaDescription(schema)})
+		case vBus.IsMethod(elem):
+			params := getKeyObj(getKeyObj(elem, "params"), "schema")
+			returns := getKeyObj(getKeyObj(elem, "returns"), "schema")
+			rows = append(rows, elementRow{strings.Join(elemPath, "."), "method", getMethodType(params, returns),
+				"", getSchemaDescription(params)})
+		default:
+			child, _ := elem.(vBus.JsonObj)
+			rows = append(rows, elementRow{strings.Join(elemPath, "."), "node", "",
+				fmt.Sprintf("(%d children)", len(child)), ""})
+			if depth <= 0 || level+1 < depth {
+				rows = append(rows, getElementRows(child, elemPath, level+1, depth)...)
+			}
+		}
+	}
+	return rows
+}
+
+// Print the elements of a raw vBus tree as a table sorted by `sortKey`.
+// Columns are truncated to fit the terminal width.
+func renderElementTable(w io.Writer, tree vBus.JsonAny, depth int, sortKey string) error {
+	column, ok := elementSortKeys[sortKey]
+	if !ok {
+		return fmt.Errorf("unknown sort key '%s' (expected path, kind, type or value)", sortKey)
+	}
+
+	rows := getElementRows(tree, nil, 0, depth)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return lessCell(rows[i][column], rows[j][column])
+	})
+
+	widths := getColumnWidths(rows)
+	if system.IsTty() {
+		fitColumnWidths(widths, getTerminalWidth())
+	}
+
+	writeRow := func(row elementRow) error {
+		var cells []string
+		for i, cell := range row {
+			cell = truncateCell(cell, widths[i])
+			if i < len(row)-1 {
+				cell += strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			}
+			cells = append(cells, cell)
+		}
+		_, err := fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, "  "), " "))
+		return err
+	}
+
+	var header elementRow
+	copy(header[:], elementColumns)
+	if err := writeRow(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the natural width of each column.
+func getColumnWidths(rows []elementRow) []int {
+	widths := make([]int, len(elementColumns))
+	for i, title := range elementColumns {
+		widths[i] = utf8.RuneCountInString(title)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	return widths
+}
+
+// Shrink columns until the table fits in `maxWidth`, description and value columns first.
+func fitColumnWidths(widths []int, maxWidth int) {
+	if maxWidth <= 0 {
+		return
+	}
+
+	total := 2 * (len(widths) - 1) // separators
+	for _, w := range widths {
+		total += w
+	}
+
+	for _, i := range []int{4, 3, 0, 2} { // description, value, path, type
+		excess := total - maxWidth
+		if excess <= 0 {
+			return
+		}
+		if reduce := widths[i] - minColumnWidth; reduce > 0 {
+			if reduce > excess {
+				reduce = excess
+			}
+			widths[i] -= reduce
+			total -= reduce
+		}
+	}
+}
+
+// Truncate a cell to `width` runes, with an ellipsis when cut.
+func truncateCell(cell string, width int) string {
+	if utf8.RuneCountInString(cell) <= width {
+		return cell
+	}
+	runes := []rune(cell)
+	return string(runes[:width-1]) + "…"
+}
+
+// Format a value for a single line table cell.
+func formatCell(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(formatLeaf(value)), " ")
+}
+
+// Get the type declared by a Json-Schema, e.g. "integer" or "string|null".
+func getSchemaType(schema vBus.JsonObj) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		var names []string
+		for _, name := range t {
+			if str, ok := name.(string); ok {
+				names = append(names, str)
+			}
+		}
+		return strings.Join(names, "|")
+	}
+	if _, ok := schema["enum"]; ok {
+		return "enum"
+	}
+	return ""
+}
+
+// Get a method signature from its params and returns schemas, e.g. "(delay integer) -> string".
+func getMethodType(params vBus.JsonObj, returns vBus.JsonObj) string {
+	var args []string
+	for i, item := range getParamsItems(params) {
+		itemObj, _ := item.(vBus.JsonObj)
+		arg := getSchemaType(itemObj)
+		if title, ok := itemObj["title"].(string); ok && title != "" {
+			arg = strings.TrimSpace(title + " " + arg)
+		} else if arg == "" {
+			arg = fmt.Sprintf("#%d", i)
+		}
+		args = append(args, arg)
+	}
+
+	signature := "(" + strings.Join(args, ", ") + ")"
+	if returnType := getSchemaType(returns); returnType != "" {
+		signature += " -> " + returnType
+	}
+	return signature
+}
+
+// Get the description (or title) of a Json-Schema.
+func getSchemaDescription(schema vBus.JsonObj) string {
+	if description, ok := schema["description"].(string); ok && description != "" {
+		return formatCell(description)
+	}
+	if title, ok := schema["title"].(string); ok {
+		return formatCell(title)
+	}
+	return ""
+}
+
+// Get an object stored in a key, or nil if missing or not an object.
+func getKeyObj(value interface{}, key string) vBus.JsonObj {
+	obj, _ := value.(vBus.JsonObj)
+	child, _ := obj[key].(vBus.JsonObj)
+	return child
+}
+
+// Get the terminal width from the COLUMNS env. variable or the terminal size.
+func getTerminalWidth() int {
+	var width int
+	if _, err := fmt.Sscan(os.Getenv("COLUMNS"), &width); err == nil && width > 0 {
+		return width
+	}
+	return getTerminalSize()
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestLessCell(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"9", "10", true},
+		{"10", "9", false},
+		{"-1.5", "0", true},
+		{"1e3", "999", false},
+		{"10", "abc", true}, // numbers first
+		{"abc", "10", false},
+		{"abc", "abd", true},
+		{"\"9\"", "\"10\"", false}, // Json strings are compared as text
+		{"", "1", false},
+	}
+	for _, tt := range tests {
+		if got := lessCell(tt.a, tt.b); got != tt.want {
+			t.Errorf("lessCell(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
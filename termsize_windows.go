@@ -0,0 +1,7 @@
+package main
+
+// Get the terminal width of stdout, 0 if unknown.
+// Not supported on Windows, use the COLUMNS env. variable.
+func getTerminalSize() int {
+	return 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veeainc/utils.go/system"
+	vBus "github.com/veeainc/vbus.go"
+)
+
+// A 'set' notification, printed as a Json line.
+type setEvent struct {
+	Time  string      `json:"time"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// An 'add' or 'del' notification, printed as a Json line.
+type nodeEvent struct {
+	Time  string      `json:"time"`
+	Event string      `json:"event"`
+	Path  string      `json:"path"`
+	Kind  string      `json:"kind"`
+	Tree  interface{} `json:"tree"`
+}
+
+// Wait for Ctrl+C or until done is closed.
+func waitForCtrlCOrDone(done <-chan struct{}) {
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	defer signal.Stop(signalChannel)
+
+	select {
+	case <-signalChannel:
+	case <-done:
+	}
+}
+
+// Print attribute 'set' notifications until Ctrl+C is pressed.
+// It stops earlier after `count` notifications (0 means no limit) or when
+// the received value is equal to `until` (only when hasUntil is true).
+func watchAttribute(attr *vBus.AttributeProxy, count int, until interface{}, hasUntil bool) error {
+	done := make(chan struct{})
+	var once sync.Once
+	var mutex sync.Mutex
+	received := 0
+
+	err := attr.SubscribeSet(func(proxy *vBus.UnknownProxy, segments ...string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		select {
+		case <-done:
+			return // notifications queued after the last expected one
+		default:
+		}
+
+		printOutput(setEvent{
+			Time:  time.Now().Format(time.RFC3339Nano),
+			Path:  attr.GetPath(),
+			Value: proxy.Tree(),
+		}, formatJson)
+
+		received++
+		if (count > 0 && received >= count) || (hasUntil && reflect.DeepEqual(proxy.Tree(), until)) {
+			once.Do(func() { close(done) })
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer attr.Unsubscribe()
+
+	waitForCtrlCOrDone(done)
+	return nil
+}
+
+// Print node 'add' and/or 'del' notifications until Ctrl+C is pressed.
+// A notification may contain several elements, one line is printed for each of them.
+func watchNode(node *vBus.NodeProxy, events []string) error {
+	var mutex sync.Mutex
+
+	receiver := func(event string) vBus.ProxySubCallback {
+		return func(proxy *vBus.UnknownProxy, segments ...string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			obj, ok := proxy.Tree().(vBus.JsonObj)
+			if !ok {
+				return // not a valid notification
+			}
+
+			parentPath := strings.Join(append([]string{proxy.GetPath()}, segments...), ".")
+			for name, tree := range obj {
+				printOutput(nodeEvent{
+					Time:  time.Now().Format(time.RFC3339Nano),
+					Event: event,
+					Path:  parentPath + "." + name,
+					Kind:  getElementKind(tree),
+					Tree:  tree,
+				}, formatJson)
+			}
+		}
+	}
+
+	for _, event := range events {
+		var err error
+		switch event {
+		case "add":
+			err = node.SubscribeAdd(receiver(event))
+		case "del":
+			err = node.SubscribeDel(receiver(event))
+		default:
+			err = errors.New("unknown event: " + event)
+		}
+		if err != nil {
+			_ = node.Unsubscribe()
+			return err
+		}
+	}
+	defer node.Unsubscribe()
+
+	system.WaitForCtrlC()
+	return nil
+}